@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// adminAPI lets operators idle, wake and pause functions without
+// redeploying the idler. Scaling goes through the controller's Scaler so
+// that dry-run is respected, and is recorded in the audit trail.
+type adminAPI struct {
+	scaler Scaler
+
+	// credentials are the gateway's, accepted as basic auth
+	credentials *Credentials
+
+	// token is accepted as a bearer token, in addition to the gateway's
+	// basic auth credentials
+	token string
+
+	state *idlerState
+
+	// leader reports whether this idler reconciles, changes are refused by
+	// followers as only the leader acts on them. Always leading when nil.
+	leader func() bool
+}
+
+// adminResult is written back for each admin action
+type adminResult struct {
+	Action   string     `json:"action"`
+	Function string     `json:"function,omitempty"`
+	Replicas *uint64    `json:"replicas,omitempty"`
+	Until    *time.Time `json:"until,omitempty"`
+	DryRun   bool       `json:"dryRun"`
+}
+
+// register adds the admin routes to mux
+func (a *adminAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("/functions/", a.authenticated(a.handleFunction))
+	mux.HandleFunc("/pause", a.authenticated(a.handlePause))
+	mux.HandleFunc("/resume", a.authenticated(a.handleResume))
+	mux.HandleFunc("/exemptions", a.authenticated(a.handleExemptions))
+}
+
+// authenticated rejects requests without valid credentials and passes on
+// who made the others
+func (a *adminAPI) authenticated(next func(w http.ResponseWriter, r *http.Request, principal string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := a.authenticate(r)
+		if !ok {
+			log.Warn("admin request rejected", "path", r.URL.Path, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Basic realm="faas-idler"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method != http.MethodGet && a.leader != nil && !a.leader() {
+			http.Error(w, "this idler is a follower, send changes to the leader", http.StatusServiceUnavailable)
+			return
+		}
+
+		next(w, r, principal)
+	}
+}
+
+// authenticate returns who made the request
+func (a *adminAPI) authenticate(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		token := strings.TrimPrefix(header, "Bearer ")
+		if len(a.token) > 0 && subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1 {
+			return "token", true
+		}
+		return "", false
+	}
+
+	user, password, ok := r.BasicAuth()
+	if !ok || a.credentials == nil || len(a.credentials.Username) == 0 {
+		return "", false
+	}
+
+	userMatch := subtle.ConstantTimeCompare([]byte(user), []byte(a.credentials.Username)) == 1
+	passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(a.credentials.Password)) == 1
+	if userMatch && passwordMatch {
+		return user, true
+	}
+	return "", false
+}
+
+// handleFunction serves POST /functions/{name}/{idle,wake,pause-idling,resume-idling}
+func (a *adminAPI) handleFunction(w http.ResponseWriter, r *http.Request, principal string) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/functions/"), "/"), "/")
+	if len(parts) != 2 || len(parts[0]) == 0 {
+		http.Error(w, "use /functions/{name}/{action}", http.StatusNotFound)
+		return
+	}
+	name, action := parts[0], parts[1]
+
+	actionLog := log.With("audit", true, "principal", principal, "remote", r.RemoteAddr, "action", action, "function", name)
+	result := adminResult{Action: action, Function: name, DryRun: dryRun}
+
+	switch action {
+	case "idle", "wake":
+		var replicas uint64
+		if action == "wake" {
+			replicas = 1
+			if val := r.URL.Query().Get("replicas"); len(val) > 0 {
+				parsed, err := strconv.ParseUint(val, 10, 64)
+				if err != nil || parsed == 0 {
+					http.Error(w, fmt.Sprintf("replicas must be a positive number: %s", val), http.StatusBadRequest)
+					return
+				}
+				replicas = parsed
+			}
+		}
+
+		record := AuditRecord{
+			Function:  name,
+			Namespace: r.URL.Query().Get("namespace"),
+			Replicas:  replicas,
+			Reason:    "admin-" + action,
+			Principal: principal,
+		}
+		fn := Function{Name: name, Namespace: record.Namespace}
+		if current, err := a.scaler.GetReplicas(r.Context(), fn); err == nil {
+			record.PreviousReplicas = &current.Replicas
+			if len(current.Namespace) > 0 {
+				record.Namespace = current.Namespace
+			}
+		}
+
+		if err := scaleAudited(r.Context(), a.scaler, record); err != nil {
+			actionLog.Warn("admin action failed", "replicas", replicas, "err", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		result.Replicas = &replicas
+		actionLog.Info("admin action", "replicas", replicas, "dry_run", dryRun)
+
+		eventType := eventIdled
+		if action == "wake" {
+			eventType = eventWoken
+		}
+		notifications.Emit(Event{
+			Type:      eventType,
+			Time:      time.Now(),
+			Function:  name,
+			Namespace: record.Namespace,
+			Reason:    Reason(record.Reason),
+			Replicas:  &replicas,
+			Principal: principal,
+			DryRun:    dryRun,
+		})
+
+	case "pause-idling":
+		duration, err := time.ParseDuration(r.URL.Query().Get("for"))
+		if err != nil || duration <= 0 {
+			http.Error(w, "for must be a positive duration, i.e. ?for=2h", http.StatusBadRequest)
+			return
+		}
+
+		until := time.Now().Add(duration)
+		if err := a.state.exemptions.Add(Exemption{
+			Pattern: name,
+			Until:   until,
+			Source:  exemptionSourceAdmin,
+		}); err != nil {
+			http.Error(w, fmt.Sprintf("invalid pattern: %s", err), http.StatusBadRequest)
+			return
+		}
+		result.Until = &until
+		actionLog.Info("admin action", "until", until)
+
+	case "resume-idling":
+		a.state.exemptions.Remove(name)
+		actionLog.Info("admin action")
+
+	default:
+		http.Error(w, "unknown action: "+action, http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handlePause serves POST /pause, which stops all scale-downs for the
+// duration given by ?for= or until POST /resume.
+func (a *adminAPI) handlePause(w http.ResponseWriter, r *http.Request, principal string) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	result := adminResult{Action: "pause", DryRun: dryRun}
+
+	var until time.Time
+	if val := r.URL.Query().Get("for"); len(val) > 0 {
+		duration, err := time.ParseDuration(val)
+		if err != nil || duration <= 0 {
+			http.Error(w, "for must be a positive duration, i.e. ?for=2h", http.StatusBadRequest)
+			return
+		}
+		until = time.Now().Add(duration)
+		result.Until = &until
+	}
+
+	a.state.pause(until)
+	log.Info("admin action", "audit", true, "principal", principal, "remote", r.RemoteAddr, "action", "pause", "until", until)
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleResume serves POST /resume, ending a global pause
+func (a *adminAPI) handleResume(w http.ResponseWriter, r *http.Request, principal string) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	a.state.resume()
+	log.Info("admin action", "audit", true, "principal", principal, "remote", r.RemoteAddr, "action", "resume")
+
+	writeJSON(w, http.StatusOK, adminResult{Action: "resume", DryRun: dryRun})
+}
+
+// handleExemptions lists exemptions on GET, adds one for ?pattern= on POST
+// with either ?until= as RFC3339 or ?for= as a duration, and removes the
+// one for ?pattern= on DELETE.
+func (a *adminAPI) handleExemptions(w http.ResponseWriter, r *http.Request, principal string) {
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, a.state.exemptions.List(time.Now()))
+		return
+	}
+
+	pattern := r.URL.Query().Get("pattern")
+	if len(pattern) == 0 {
+		http.Error(w, "pattern is required, i.e. ?pattern=payments-*", http.StatusBadRequest)
+		return
+	}
+	actionLog := log.With("audit", true, "principal", principal, "remote", r.RemoteAddr, "pattern", pattern)
+
+	switch r.Method {
+	case http.MethodPost:
+		until, err := parseUntil(r.URL.Query().Get("until"), r.URL.Query().Get("for"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		exemption := Exemption{
+			Pattern: pattern,
+			Until:   until,
+			Source:  exemptionSourceAdmin,
+		}
+		if err := a.state.exemptions.Add(exemption); err != nil {
+			http.Error(w, fmt.Sprintf("invalid pattern: %s", err), http.StatusBadRequest)
+			return
+		}
+		actionLog.Info("admin action", "action", "exempt", "until", until)
+		writeJSON(w, http.StatusOK, exemption)
+
+	case http.MethodDelete:
+		if !a.state.exemptions.Remove(pattern) {
+			http.Error(w, "no exemption for pattern: "+pattern, http.StatusNotFound)
+			return
+		}
+		actionLog.Info("admin action", "action", "unexempt")
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// parseUntil reads an expiry given as an RFC3339 time or as a duration
+// from now
+func parseUntil(until string, duration string) (time.Time, error) {
+	if len(until) > 0 {
+		parsed, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("until must be an RFC3339 time: %s", err)
+		}
+		return parsed, nil
+	}
+
+	parsed, err := time.ParseDuration(duration)
+	if err != nil || parsed <= 0 {
+		return time.Time{}, fmt.Errorf("give until as an RFC3339 time, or for as a positive duration, i.e. ?for=2h")
+	}
+	return time.Now().Add(parsed), nil
+}
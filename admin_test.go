@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	providerTypes "github.com/openfaas/faas-provider/types"
+)
+
+func newTestAdmin(t *testing.T) (*adminAPI, http.Handler, *[]providerTypes.ScaleServiceRequest, func()) {
+	scaled := []providerTypes.ScaleServiceRequest{}
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`{"name":"figlet","namespace":"openfaas-fn","replicas":1,"availableReplicas":1}`))
+			return
+		}
+
+		req := providerTypes.ScaleServiceRequest{}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("unable to read scale request: %s", err)
+		}
+		scaled = append(scaled, req)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	credentials := &Credentials{Username: "admin", Password: "secret"}
+	admin := &adminAPI{
+		scaler: &gatewayScaler{
+			client:      gateway.Client(),
+			gatewayURL:  gateway.URL + "/",
+			credentials: credentials,
+		},
+		credentials: credentials,
+		token:       "token",
+		state:       newIdlerState(),
+	}
+	mux := http.NewServeMux()
+	admin.register(mux)
+
+	return admin, mux, &scaled, gateway.Close
+}
+
+func Test_AdminRejectsUnauthenticated(t *testing.T) {
+	_, handler, scaled, done := newTestAdmin(t)
+	defer done()
+
+	cases := []struct {
+		name      string
+		authorise func(r *http.Request)
+	}{
+		{name: "no credentials", authorise: func(r *http.Request) {}},
+		{name: "wrong password", authorise: func(r *http.Request) { r.SetBasicAuth("admin", "wrong") }},
+		{name: "wrong token", authorise: func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") }},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/functions/figlet/idle", nil)
+			c.authorise(req)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("want status: %d, got: %d", http.StatusUnauthorized, rec.Code)
+			}
+		})
+	}
+
+	if len(*scaled) != 0 {
+		t.Errorf("want no scale requests, got: %v", *scaled)
+	}
+}
+
+func Test_AdminIdleAndWake(t *testing.T) {
+	_, handler, scaled, done := newTestAdmin(t)
+	defer done()
+
+	req := httptest.NewRequest(http.MethodPost, "/functions/figlet/idle", nil)
+	req.SetBasicAuth("admin", "secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("idle want status: %d, got: %d, body: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/functions/figlet/wake?replicas=3", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wake want status: %d, got: %d, body: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	if len(*scaled) != 2 {
+		t.Fatalf("want 2 scale requests, got: %d", len(*scaled))
+	}
+	if (*scaled)[0].ServiceName != "figlet" || (*scaled)[0].Replicas != 0 {
+		t.Errorf("want figlet scaled to 0, got: %+v", (*scaled)[0])
+	}
+	if (*scaled)[1].Replicas != 3 {
+		t.Errorf("want figlet scaled to 3, got: %+v", (*scaled)[1])
+	}
+}
+
+func Test_AdminRespectsDryRun(t *testing.T) {
+	admin, handler, scaled, done := newTestAdmin(t)
+	defer done()
+
+	dryRun = true
+	defer func() { dryRun = false }()
+	admin.scaler = newDryRunScaler(admin.scaler)
+
+	req := httptest.NewRequest(http.MethodPost, "/functions/figlet/idle", nil)
+	req.SetBasicAuth("admin", "secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want status: %d, got: %d", http.StatusOK, rec.Code)
+	}
+	if len(*scaled) != 0 {
+		t.Errorf("want no scale requests in dry-run, got: %v", *scaled)
+	}
+}
+
+func Test_AdminPause(t *testing.T) {
+	admin, handler, _, done := newTestAdmin(t)
+	defer done()
+
+	req := httptest.NewRequest(http.MethodPost, "/functions/figlet/pause-idling?for=2h", nil)
+	req.SetBasicAuth("admin", "secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want status: %d, got: %d", http.StatusOK, rec.Code)
+	}
+	if _, ok := admin.state.exemptions.Match("figlet", time.Now()); !ok {
+		t.Errorf("want figlet exempt")
+	}
+	if _, ok := admin.state.exemptions.Match("figlet", time.Now().Add(3*time.Hour)); ok {
+		t.Errorf("want figlet exemption to expire after 2h")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/pause", nil)
+	req.SetBasicAuth("admin", "secret")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !admin.state.isPaused(time.Now().Add(24 * time.Hour)) {
+		t.Errorf("want every function paused until resumed")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/resume", nil)
+	req.SetBasicAuth("admin", "secret")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if admin.state.isPaused(time.Now()) {
+		t.Errorf("want pause to end after resume")
+	}
+}
+
+func Test_AdminPauseInvalidPattern(t *testing.T) {
+	admin, handler, _, done := newTestAdmin(t)
+	defer done()
+
+	req := httptest.NewRequest(http.MethodPost, "/functions/figlet%5B/pause-idling?for=2h", nil)
+	req.SetBasicAuth("admin", "secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("want status: %d, got: %d", http.StatusBadRequest, rec.Code)
+	}
+	if list := admin.state.exemptions.List(time.Now()); len(list) != 0 {
+		t.Errorf("want no exemptions, got: %v", list)
+	}
+}
+
+func Test_AdminFollowerIsReadOnly(t *testing.T) {
+	admin, handler, scaled, done := newTestAdmin(t)
+	defer done()
+	admin.leader = func() bool { return false }
+
+	req := httptest.NewRequest(http.MethodPost, "/functions/figlet/idle", nil)
+	req.SetBasicAuth("admin", "secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("want status: %d, got: %d", http.StatusServiceUnavailable, rec.Code)
+	}
+	if len(*scaled) != 0 {
+		t.Errorf("want no scale requests from a follower, got: %d", len(*scaled))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/exemptions", nil)
+	req.SetBasicAuth("admin", "secret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("want reads allowed on a follower, got status: %d", rec.Code)
+	}
+}
+
+func Test_AdminExemptions(t *testing.T) {
+	admin, handler, _, done := newTestAdmin(t)
+	defer done()
+
+	req := httptest.NewRequest(http.MethodPost, "/exemptions?pattern=payments-*&for=1h", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want status: %d, got: %d, body: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if _, ok := admin.state.exemptions.Match("payments-api", time.Now()); !ok {
+		t.Errorf("want payments-api exempt")
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/exemptions?pattern=payments-*", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("want status: %d, got: %d", http.StatusNoContent, rec.Code)
+	}
+	if _, ok := admin.state.exemptions.Match("payments-api", time.Now()); ok {
+		t.Errorf("want payments-api exemption removed")
+	}
+}
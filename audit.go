@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditRecord is written for every scale request the idler makes, or would
+// make in dry-run
+type AuditRecord struct {
+	Time             time.Time `json:"time"`
+	Function         string    `json:"function"`
+	Namespace        string    `json:"namespace,omitempty"`
+	PreviousReplicas *uint64   `json:"previousReplicas,omitempty"`
+	Replicas         uint64    `json:"replicas"`
+	Reason           string    `json:"reason"`
+	Principal        string    `json:"principal,omitempty"`
+	Evidence         *Evidence `json:"evidence,omitempty"`
+	DryRun           bool      `json:"dryRun"`
+	StatusCode       int       `json:"statusCode,omitempty"`
+	LatencySeconds   float64   `json:"latencySeconds"`
+	Error            string    `json:"error,omitempty"`
+}
+
+// Evidence is what a scale-down was decided on
+type Evidence struct {
+	Counters *Counters `json:"counters,omitempty"`
+	Window   Duration  `json:"window"`
+}
+
+// auditSink stores audit records
+type auditSink interface {
+	Write(record AuditRecord) error
+	Close() error
+}
+
+// auditor writes each record to every sink
+type auditor struct {
+	sinks []auditSink
+}
+
+func (a *auditor) add(sink auditSink) {
+	a.sinks = append(a.sinks, sink)
+}
+
+// Record writes to every sink, failures are logged so that an unavailable
+// sink never stops scaling
+func (a *auditor) Record(record AuditRecord) {
+	for _, sink := range a.sinks {
+		if err := sink.Write(record); err != nil {
+			log.Warn("unable to write audit record", "function", record.Function, "err", err)
+		}
+	}
+}
+
+// Close closes every sink
+func (a *auditor) Close() {
+	for _, sink := range a.sinks {
+		if err := sink.Close(); err != nil {
+			log.Warn("unable to close audit sink", "err", err)
+		}
+	}
+}
+
+// fileAuditSink appends records to a file as JSON lines, syncing after each
+// so that records survive a crash
+type fileAuditSink struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+func newFileAuditSink(path string) (*fileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileAuditSink{file: file}, nil
+}
+
+func (f *fileAuditSink) Write(record AuditRecord) error {
+	bytesOut, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if _, err := f.file.Write(append(bytesOut, '\n')); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+func (f *fileAuditSink) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.file.Close()
+}
+
+// webhookAuditSink POSTs each record as JSON
+type webhookAuditSink struct {
+	client *http.Client
+	url    string
+}
+
+func newWebhookAuditSink(url string) *webhookAuditSink {
+	return &webhookAuditSink{
+		client: &http.Client{Timeout: 5 * time.Second},
+		url:    url,
+	}
+}
+
+func (w *webhookAuditSink) Write(record AuditRecord) error {
+	bodyBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	res, err := w.client.Post(w.url, "application/json", bytes.NewReader(bodyBytes))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status code from audit webhook: %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (w *webhookAuditSink) Close() error {
+	return nil
+}
+
+// scaleAudited sends a scale request and records it in the audit trail,
+// record describes the request and is completed with the response
+func scaleAudited(ctx context.Context, scaler Scaler, record AuditRecord) error {
+	record.Time = time.Now()
+	record.DryRun = dryRun
+
+	fn := Function{Name: record.Function, Namespace: record.Namespace}
+	res, err := scaler.Scale(ctx, fn, record.Replicas)
+	record.StatusCode = res.StatusCode
+	record.LatencySeconds = res.Latency.Seconds()
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	audit.Record(record)
+	return err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_ScaleAuditedWritesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "faas-idler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink, err := newFileAuditSink(path.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := audit
+	audit = &auditor{}
+	audit.add(sink)
+	defer func() { audit = previous }()
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer gateway.Close()
+
+	scaler := &gatewayScaler{
+		client:      gateway.Client(),
+		gatewayURL:  gateway.URL + "/",
+		credentials: &Credentials{},
+	}
+
+	replicas := uint64(2)
+	err = scaleAudited(context.Background(), scaler, AuditRecord{
+		Function:         "figlet",
+		Namespace:        "openfaas-fn",
+		PreviousReplicas: &replicas,
+		Replicas:         0,
+		Reason:           string(ReasonIdle),
+		Evidence: &Evidence{
+			Counters: &Counters{Cached: 3, First: 3, Second: 3},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.Close()
+
+	file, err := os.Open(path.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	records := []AuditRecord{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		record := AuditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("audit line is not JSON: %s", err)
+		}
+		records = append(records, record)
+	}
+
+	if len(records) != 1 {
+		t.Fatalf("want 1 audit record, got: %d", len(records))
+	}
+
+	record := records[0]
+	if record.Function != "figlet" || record.Replicas != 0 || *record.PreviousReplicas != 2 {
+		t.Errorf("want figlet scaled from 2 to 0, got: %+v", record)
+	}
+	if record.StatusCode != http.StatusAccepted {
+		t.Errorf("want status code: %d, got: %d", http.StatusAccepted, record.StatusCode)
+	}
+	if record.Time.IsZero() {
+		t.Errorf("want time to be set")
+	}
+	if record.Evidence == nil || record.Evidence.Counters.Second != 3 {
+		t.Errorf("want evidence to be kept, got: %+v", record.Evidence)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned instead of calling a backend which has failed
+// too many times in a row
+var errCircuitOpen = errors.New("circuit breaker open, backend unhealthy")
+
+// circuitBreaker stops calls to a backend after threshold consecutive
+// failures. Once cooldown has passed calls are let through again, and the
+// first success closes the circuit while a failure opens it for another
+// cooldown.
+type circuitBreaker struct {
+	lock      sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Allow returns errCircuitOpen while the circuit is open
+func (b *circuitBreaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.tripped() && time.Now().Before(b.openUntil) {
+		return errCircuitOpen
+	}
+	return nil
+}
+
+// Record counts the outcome of a call, failed is true when the backend
+// couldn't be reached or answered with a server error
+func (b *circuitBreaker) Record(failed bool) {
+	if b == nil {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if !failed {
+		if b.tripped() {
+			log.Info("circuit breaker closed, backend healthy again")
+		}
+		b.failures = 0
+		circuitOpen.Set(0)
+		return
+	}
+
+	b.failures++
+	if b.tripped() {
+		if time.Now().After(b.openUntil) {
+			log.Warn("circuit breaker open, pausing calls to backend", "failures", b.failures, "cooldown", b.cooldown)
+		}
+		b.openUntil = time.Now().Add(b.cooldown)
+		circuitOpen.Set(1)
+	}
+}
+
+// Open reports whether calls are being stopped
+func (b *circuitBreaker) Open() bool {
+	return b.Allow() == errCircuitOpen
+}
+
+func (b *circuitBreaker) tripped() bool {
+	return b.threshold > 0 && b.failures >= b.threshold
+}
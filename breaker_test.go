@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_CircuitBreaker(t *testing.T) {
+	breaker := newCircuitBreaker(3, 20*time.Millisecond)
+
+	breaker.Record(true)
+	breaker.Record(true)
+	if err := breaker.Allow(); err != nil {
+		t.Fatalf("want closed below the threshold, got: %s", err)
+	}
+
+	breaker.Record(true)
+	if err := breaker.Allow(); err != errCircuitOpen {
+		t.Fatalf("want open at the threshold, got: %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if err := breaker.Allow(); err != nil {
+		t.Fatalf("want a trial call after the cooldown, got: %s", err)
+	}
+
+	breaker.Record(true)
+	if err := breaker.Allow(); err != errCircuitOpen {
+		t.Fatalf("want open again after a failed trial, got: %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	breaker.Record(false)
+	breaker.Record(true)
+	if err := breaker.Allow(); err != nil {
+		t.Errorf("want closed after a successful trial, got: %s", err)
+	}
+}
+
+func Test_CircuitBreakerNil(t *testing.T) {
+	var breaker *circuitBreaker
+	breaker.Record(true)
+
+	if err := breaker.Allow(); err != nil {
+		t.Errorf("want a nil breaker to allow every call, got: %s", err)
+	}
+}
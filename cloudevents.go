@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	cloudEventSource      = "faas-idler"
+	cloudEventTypePrefix  = "com.openfaas.idler.function."
+	cloudEventContentType = "application/cloudevents+json"
+
+	// cloudEventPlanned is the type of a function which would have been
+	// idled or woken in dry-run
+	cloudEventPlanned = "planned"
+)
+
+// cloudEvent is a CloudEvent in structured JSON mode, data is the Event
+type cloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	Type            string    `json:"type"`
+	Source          string    `json:"source"`
+	ID              string    `json:"id"`
+	Time            time.Time `json:"time"`
+	Subject         string    `json:"subject"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            Event     `json:"data"`
+}
+
+// cloudEventType is one of com.openfaas.idler.function.idled, .woken,
+// .planned or .skipped. Failures to scale or evaluate are skipped, with the
+// detail kept in data.type and data.reason, and nothing is idled or woken
+// in dry-run, so that consumers never act on a scale which didn't happen.
+func cloudEventType(event Event) string {
+	switch event.Type {
+	case eventIdled, eventWoken:
+		if event.DryRun {
+			return cloudEventTypePrefix + cloudEventPlanned
+		}
+		return cloudEventTypePrefix + event.Type
+	}
+	return cloudEventTypePrefix + eventSkipped
+}
+
+// newCloudEventSink POSTs the given types of CloudEvent to url, or every
+// event when none are given. Types are those published, with or without the
+// com.openfaas.idler.function. prefix. Functions skipped for not opting in
+// are never sent, as every unlabelled function would be sent each cycle.
+func newCloudEventSink(url string, types ...string) (*webhookNotifier, error) {
+	events := []string{}
+	for _, eventType := range types {
+		eventType = strings.TrimPrefix(eventType, cloudEventTypePrefix)
+		switch eventType {
+		case eventIdled, eventWoken, cloudEventPlanned, eventSkipped:
+		default:
+			return nil, fmt.Errorf("unknown CloudEvent type: %s, use idled, woken, planned or skipped", eventType)
+		}
+		events = append(events, eventType)
+	}
+
+	n, err := newWebhookNotifier(url, webhookFormatCloudEvents, events...)
+	if err != nil {
+		return nil, err
+	}
+	n.ignoredReasons = map[Reason]bool{ReasonMissingLabel: true}
+	n.typeOf = func(event Event) string {
+		return strings.TrimPrefix(cloudEventType(event), cloudEventTypePrefix)
+	}
+	return n, nil
+}
+
+func cloudEventPayload(event Event) ([]byte, string, error) {
+	id, err := newEventID()
+	if err != nil {
+		return nil, "", err
+	}
+
+	subject := event.Function
+	if len(event.Namespace) > 0 {
+		subject = event.Namespace + "/" + event.Function
+	}
+
+	body, err := json.Marshal(cloudEvent{
+		SpecVersion:     "1.0",
+		Type:            cloudEventType(event),
+		Source:          cloudEventSource,
+		ID:              id,
+		Time:            event.Time,
+		Subject:         subject,
+		DataContentType: "application/json",
+		Data:            event,
+	})
+	return body, cloudEventContentType, err
+}
+
+func newEventID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_cloudEventType(t *testing.T) {
+	cases := []struct {
+		name      string
+		eventType string
+		dryRun    bool
+		want      string
+	}{
+		{name: "idled", eventType: eventIdled, want: "com.openfaas.idler.function.idled"},
+		{name: "woken", eventType: eventWoken, want: "com.openfaas.idler.function.woken"},
+		{name: "skipped", eventType: eventSkipped, want: "com.openfaas.idler.function.skipped"},
+		{name: "scale failed", eventType: eventScaleFailed, want: "com.openfaas.idler.function.skipped"},
+		{name: "unevaluated", eventType: eventUnevaluated, want: "com.openfaas.idler.function.skipped"},
+		{name: "idled in dry-run", eventType: eventIdled, dryRun: true, want: "com.openfaas.idler.function.planned"},
+		{name: "woken in dry-run", eventType: eventWoken, dryRun: true, want: "com.openfaas.idler.function.planned"},
+		{name: "skipped in dry-run", eventType: eventSkipped, dryRun: true, want: "com.openfaas.idler.function.skipped"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := cloudEventType(Event{Type: c.eventType, DryRun: c.dryRun}); got != c.want {
+				t.Errorf("want type: %s, got: %s", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_CloudEventSinkSendsEveryEvent(t *testing.T) {
+	receiver, received := newTestReceiver()
+	defer receiver.Close()
+
+	sink, err := newCloudEventSink(receiver.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counters := &Counters{Cached: 4, First: 4, Second: 5}
+	sink.Emit(eventFor(Decision{
+		Function:  "figlet",
+		Namespace: "openfaas-fn",
+		Action:    actionSkip,
+		Reason:    ReasonCounterChanged,
+		Counters:  counters,
+	}))
+	sink.Emit(testEvent())
+	sink.Close()
+
+	got := received()
+	if len(got) != 2 {
+		t.Fatalf("want 2 CloudEvents, got: %d", len(got))
+	}
+
+	event := cloudEvent{}
+	if err := json.Unmarshal(got[0].body, &event); err != nil {
+		t.Fatalf("CloudEvent is not JSON: %s", err)
+	}
+	if got[0].contentType != cloudEventContentType {
+		t.Errorf("want content type: %s, got: %s", cloudEventContentType, got[0].contentType)
+	}
+	if event.Type != "com.openfaas.idler.function.skipped" || event.Subject != "openfaas-fn/figlet" {
+		t.Errorf("want figlet skipped, got: %s for %s", event.Type, event.Subject)
+	}
+	if event.Data.Decision == nil || *event.Data.Decision.Counters != *counters {
+		t.Errorf("want the decision's counters as evidence, got: %+v", event.Data.Decision)
+	}
+}
+
+func Test_AdminWakeEmitsWoken(t *testing.T) {
+	receiver, received := newTestReceiver()
+	defer receiver.Close()
+
+	sink, err := newCloudEventSink(receiver.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := notifications
+	notifications = &notifier{}
+	notifications.add(sink)
+	defer func() { notifications = previous }()
+
+	_, handler, _, done := newTestAdmin(t)
+	defer done()
+
+	req := httptest.NewRequest(http.MethodPost, "/functions/figlet/wake", nil)
+	req.SetBasicAuth("admin", "secret")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	sink.Close()
+
+	got := received()
+	if len(got) != 1 {
+		t.Fatalf("want 1 CloudEvent, got: %d", len(got))
+	}
+
+	event := cloudEvent{}
+	if err := json.Unmarshal(got[0].body, &event); err != nil {
+		t.Fatalf("CloudEvent is not JSON: %s", err)
+	}
+	if event.Type != "com.openfaas.idler.function.woken" || event.Data.Principal != "admin" {
+		t.Errorf("want figlet woken by admin, got: %s by %q", event.Type, event.Data.Principal)
+	}
+}
+
+func Test_CloudEventSinkFilters(t *testing.T) {
+	cases := []struct {
+		name   string
+		events []string
+		want   int
+	}{
+		{name: "every event", want: 4},
+		{name: "idled only", events: []string{eventIdled}, want: 1},
+		{name: "skipped, including failures", events: []string{eventSkipped}, want: 2},
+		{name: "full type name", events: []string{"com.openfaas.idler.function.skipped"}, want: 2},
+		{name: "planned only", events: []string{cloudEventPlanned}, want: 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			receiver, received := newTestReceiver()
+			defer receiver.Close()
+
+			sink, err := newCloudEventSink(receiver.URL, c.events...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sink.Emit(eventFor(Decision{Function: "unlabelled", Action: actionSkip, Reason: ReasonMissingLabel}))
+			sink.Emit(eventFor(Decision{Function: "figlet", Action: actionSkip, Reason: ReasonCounterChanged}))
+			sink.Emit(eventFor(Decision{Function: "figlet", Action: actionSkip, Reason: ReasonScaleFailed}))
+			sink.Emit(testEvent())
+			sink.Emit(eventFor(Decision{Function: "figlet", Action: actionScale, Reason: ReasonIdle, DryRun: true}))
+			sink.Close()
+
+			if got := len(received()); got != c.want {
+				t.Errorf("want CloudEvents: %d, got: %d", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_CloudEventSinkUnknownType(t *testing.T) {
+	for _, eventType := range []string{"evaluated", eventScaleFailed} {
+		if _, err := newCloudEventSink("http://127.0.0.1", eventType); err == nil {
+			t.Errorf("want error for unknown event type: %s", eventType)
+		}
+	}
+}
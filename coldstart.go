@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openfaas-incubator/faas-idler/logger"
+
+	providerTypes "github.com/openfaas/faas-provider/types"
+)
+
+// coldStartAnnotation tells the idler how long a function takes to start,
+// i.e. "40s", instead of it being measured
+const coldStartAnnotation = "com.openfaas.scale.zero.cold-start"
+
+const (
+	coldStartFromAnnotation = "annotation"
+	coldStartMeasured       = "measured"
+)
+
+// ColdStart weighs what waking a function costs against how often it is
+// called, a function is not idled when ExpectedGap is shorter than
+// MinimumGap
+type ColdStart struct {
+	Duration    Duration `json:"duration"`
+	Source      string   `json:"source"`
+	ExpectedGap Duration `json:"expectedGap"`
+	MinimumGap  Duration `json:"minimumGap"`
+}
+
+// tooCostly reports whether the function is expected to be called again
+// before idling it would pay for its cold start
+func (c *ColdStart) tooCostly() bool {
+	return c != nil && c.ExpectedGap < c.MinimumGap
+}
+
+// functionSeconds is the gateway_functions_seconds histogram of a function,
+// summed over status codes
+type functionSeconds struct {
+	Sum   float64
+	Count float64
+
+	// Buckets counts the requests which took up to each upper bound
+	Buckets map[float64]float64
+}
+
+// slowest returns the upper bound of the bucket holding the slowest of the
+// requests counted since baseline, or the largest bound when it took longer
+// than all of them, false when there are no buckets to tell
+func (s functionSeconds) slowest(baseline functionSeconds) (float64, bool) {
+	bounds := []float64{}
+	for bound := range s.Buckets {
+		if !math.IsInf(bound, 1) {
+			bounds = append(bounds, bound)
+		}
+	}
+	if len(bounds) == 0 {
+		return 0, false
+	}
+	sort.Float64s(bounds)
+
+	requests := s.Count - baseline.Count
+	for _, bound := range bounds {
+		if s.Buckets[bound]-baseline.Buckets[bound] >= requests {
+			return bound, true
+		}
+	}
+	return bounds[len(bounds)-1], true
+}
+
+// coldStart returns the figures for a function, or nil when its cold start
+// isn't known or cold_start_factor is 0
+func (c *Controller) coldStart(function providerTypes.FunctionStatus, now time.Time, log *logger.Logger) *ColdStart {
+	if c.config.ColdStartFactor <= 0 {
+		return nil
+	}
+
+	duration, source := c.state.measuredColdStart(function.Name), coldStartMeasured
+	if annotated, ok, err := annotationColdStart(function.Annotations); err != nil {
+		log.Warn("ignoring invalid annotation", "annotation", coldStartAnnotation, "err", err)
+	} else if ok {
+		duration, source = annotated, coldStartFromAnnotation
+	}
+
+	if duration <= 0 {
+		return nil
+	}
+
+	expectedGap, ok := c.state.expectedGap(function.Name, now)
+	if !ok {
+		return nil
+	}
+
+	return &ColdStart{
+		Duration:    Duration(duration),
+		Source:      source,
+		ExpectedGap: Duration(expectedGap),
+		MinimumGap:  Duration(time.Duration(c.config.ColdStartFactor * float64(duration))),
+	}
+}
+
+// recordWakeBaseline keeps the request durations of a function scaled to
+// zero, so that the first requests after it is woken can be measured
+func (c *Controller) recordWakeBaseline(ctx context.Context, name string, log *logger.Logger) {
+	if c.functionSeconds == nil || c.config.ColdStartFactor <= 0 {
+		return
+	}
+
+	seconds, err := c.functionSeconds(ctx, name)
+	if err != nil {
+		log.Warn("unable to read request durations, cold start won't be measured", "err", err)
+		return
+	}
+	c.state.setWakeBaseline(name, seconds)
+}
+
+// measureColdStart takes the slowest of the requests since a function was
+// scaled to zero as its cold start, once they are seen, so that warm
+// requests after the one which woke it don't hide it. The mean is used when
+// the gateway doesn't report buckets.
+func (c *Controller) measureColdStart(ctx context.Context, name string, log *logger.Logger) {
+	baseline, ok := c.state.wakeBaseline(name)
+	if !ok || c.functionSeconds == nil {
+		return
+	}
+
+	seconds, err := c.functionSeconds(ctx, name)
+	if err != nil {
+		log.Warn("unable to read request durations", "err", err)
+		return
+	}
+
+	requests := seconds.Count - baseline.Count
+	if requests < 0 {
+		// the gateway restarted, its histogram starts again from zero
+		c.state.setWakeBaseline(name, seconds)
+		return
+	}
+	if requests == 0 {
+		return
+	}
+
+	slowest, ok := seconds.slowest(baseline)
+	if !ok {
+		slowest = (seconds.Sum - baseline.Sum) / requests
+	}
+
+	coldStart := time.Duration(slowest * float64(time.Second))
+	c.state.setMeasuredColdStart(name, coldStart)
+	log.Info("measured cold start", "cold_start", coldStart, "requests", requests)
+}
+
+// annotationColdStart reads the cold-start annotation of a function
+func annotationColdStart(annotations *map[string]string) (time.Duration, bool, error) {
+	if annotations == nil {
+		return 0, false, nil
+	}
+
+	val, ok := (*annotations)[coldStartAnnotation]
+	if !ok || len(val) == 0 {
+		return 0, false, nil
+	}
+
+	duration, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, false, err
+	}
+	return duration, duration > 0, nil
+}
+
+// gatewayFunctionSeconds reads the request durations of a function from
+// the gateway's metrics
+func gatewayFunctionSeconds(ctx context.Context, name string) (functionSeconds, error) {
+	code, body := Get(ctx, gatewayMetricsURL)
+	if code == 0 {
+		return functionSeconds{}, fmt.Errorf("unable to get metrics from %s", gatewayMetricsURL)
+	}
+	if code != http.StatusOK {
+		return functionSeconds{}, fmt.Errorf("unexpected status code for metrics: %d", code)
+	}
+
+	return parseFunctionSeconds(string(body), name)
+}
+
+// parseFunctionSeconds sums the gateway_functions_seconds histogram of a
+// function across status codes from the Prometheus text format
+func parseFunctionSeconds(metrics string, name string) (functionSeconds, error) {
+	seconds := functionSeconds{Buckets: map[float64]float64{}}
+	match := `function_name="` + name + `"`
+
+	for _, row := range strings.Split(metrics, "\n") {
+		if !strings.HasPrefix(row, "gateway_functions_seconds_") || !strings.Contains(row, match) {
+			continue
+		}
+
+		segs := strings.Fields(row)
+		value, err := strconv.ParseFloat(segs[len(segs)-1], 64)
+		if err != nil {
+			return functionSeconds{}, fmt.Errorf("unable to parse metric: %s", row)
+		}
+
+		switch {
+		case strings.HasPrefix(row, "gateway_functions_seconds_bucket{"):
+			bound, err := bucketBound(row)
+			if err != nil {
+				return functionSeconds{}, err
+			}
+			seconds.Buckets[bound] += value
+		case strings.HasPrefix(row, "gateway_functions_seconds_sum{"):
+			seconds.Sum += value
+		case strings.HasPrefix(row, "gateway_functions_seconds_count{"):
+			seconds.Count += value
+		}
+	}
+	return seconds, nil
+}
+
+// bucketBound reads the le label of a histogram bucket row
+func bucketBound(row string) (float64, error) {
+	start := strings.Index(row, `le="`)
+	if start < 0 {
+		return 0, fmt.Errorf("unable to parse metric: %s", row)
+	}
+	val := row[start+len(`le="`):]
+	end := strings.Index(val, `"`)
+	if end < 0 {
+		return 0, fmt.Errorf("unable to parse metric: %s", row)
+	}
+
+	bound, err := strconv.ParseFloat(val[:end], 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse metric: %s", row)
+	}
+	return bound, nil
+}
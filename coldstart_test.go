@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func Test_parseFunctionSeconds(t *testing.T) {
+	metrics := `# TYPE gateway_functions_seconds histogram
+gateway_functions_seconds_bucket{code="200",function_name="figlet",le="0.5"} 3
+gateway_functions_seconds_sum{code="200",function_name="figlet"} 41.5
+gateway_functions_seconds_count{code="200",function_name="figlet"} 4
+gateway_functions_seconds_sum{code="500",function_name="figlet"} 0.5
+gateway_functions_seconds_count{code="500",function_name="figlet"} 1
+gateway_functions_seconds_sum{code="200",function_name="figlet2"} 100
+gateway_functions_seconds_count{code="200",function_name="figlet2"} 100
+`
+
+	seconds, err := parseFunctionSeconds(metrics, "figlet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seconds.Sum != 42 || seconds.Count != 5 {
+		t.Errorf("want sum 42 and count 5, got: %+v", seconds)
+	}
+	if seconds.Buckets[0.5] != 3 {
+		t.Errorf("want 3 requests up to 0.5s, got: %v", seconds.Buckets)
+	}
+}
+
+func Test_functionSecondsSlowest(t *testing.T) {
+	baseline := functionSeconds{Count: 10, Buckets: map[float64]float64{1: 10, 10: 10, 60: 10, math.Inf(1): 10}}
+
+	cases := []struct {
+		name    string
+		seconds functionSeconds
+		want    float64
+		wantOk  bool
+	}{
+		{
+			name:    "no buckets",
+			seconds: functionSeconds{Count: 11},
+		},
+		{
+			name:    "slow request among warm ones",
+			seconds: functionSeconds{Count: 20, Buckets: map[float64]float64{1: 19, 10: 19, 60: 20, math.Inf(1): 20}},
+			want:    60,
+			wantOk:  true,
+		},
+		{
+			name:    "slower than every bound",
+			seconds: functionSeconds{Count: 12, Buckets: map[float64]float64{1: 11, 10: 11, 60: 11, math.Inf(1): 12}},
+			want:    60,
+			wantOk:  true,
+		},
+		{
+			name:    "all warm",
+			seconds: functionSeconds{Count: 12, Buckets: map[float64]float64{1: 12, 10: 12, 60: 12, math.Inf(1): 12}},
+			want:    1,
+			wantOk:  true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, ok := c.seconds.slowest(baseline)
+			if ok != c.wantOk || got != c.want {
+				t.Errorf("want: %v %v, got: %v %v", c.want, c.wantOk, got, ok)
+			}
+		})
+	}
+}
+
+func Test_annotationColdStart(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations *map[string]string
+		want        time.Duration
+		wantErr     bool
+	}{
+		{name: "no annotations"},
+		{name: "set", annotations: &map[string]string{coldStartAnnotation: "40s"}, want: 40 * time.Second},
+		{name: "invalid", annotations: &map[string]string{coldStartAnnotation: "slow"}, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, _, err := annotationColdStart(c.annotations)
+			if c.wantErr != (err != nil) {
+				t.Errorf("want error: %v, got: %v", c.wantErr, err)
+			}
+			if got != c.want {
+				t.Errorf("want: %s, got: %s", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_expectedGap(t *testing.T) {
+	now := time.Now()
+	s := newIdlerState()
+
+	s.records["busy"] = &functionRecord{firstSeen: now.Add(-time.Hour), lastActivity: now.Add(-10 * time.Second), meanGap: 2 * time.Minute}
+	s.records["quiet"] = &functionRecord{firstSeen: now.Add(-time.Hour), lastActivity: now.Add(-5 * time.Minute), meanGap: 2 * time.Minute}
+	s.records["unused"] = &functionRecord{firstSeen: now.Add(-time.Hour)}
+
+	cases := map[string]time.Duration{
+		"busy":   2 * time.Minute,
+		"quiet":  5 * time.Minute,
+		"unused": time.Hour,
+	}
+
+	for name, want := range cases {
+		if got, _ := s.expectedGap(name, now); got != want {
+			t.Errorf("want %s expected gap: %s, got: %s", name, want, got)
+		}
+	}
+}
+
+func Test_ControllerWeighsColdStart(t *testing.T) {
+	cases := []struct {
+		name      string
+		coldStart string
+		want      Reason
+	}{
+		{name: "unknown cold start", want: ReasonIdle},
+		{name: "cheap cold start", coldStart: "1ns", want: ReasonIdle},
+		{name: "costly cold start", coldStart: "40s", want: ReasonColdStart},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			function := labelled("figlet", 1)
+			if len(c.coldStart) > 0 {
+				function.Annotations = &map[string]string{coldStartAnnotation: c.coldStart}
+			}
+
+			scaler := newMemoryScaler(function)
+			controller := newTestController(scaler, &fakeInvocations{counts: map[string]float64{}})
+			controller.config.ColdStartFactor = 3
+			controller.functionSeconds = func(ctx context.Context, name string) (functionSeconds, error) {
+				return functionSeconds{}, nil
+			}
+
+			decisions := controller.reconcile(context.Background(), reconcileOptions{prime: true})
+			if decisions[0].Reason != c.want {
+				t.Errorf("want: %s, got: %s", c.want, decisions[0].Reason)
+			}
+		})
+	}
+}
+
+func Test_ControllerMeasuresColdStart(t *testing.T) {
+	scaler := newMemoryScaler(labelled("figlet", 1))
+	controller := newTestController(scaler, &fakeInvocations{counts: map[string]float64{}})
+	controller.config.ColdStartFactor = 3
+
+	seconds := functionSeconds{Sum: 10, Count: 10}
+	controller.functionSeconds = func(ctx context.Context, name string) (functionSeconds, error) {
+		return seconds, nil
+	}
+
+	decisions := controller.reconcile(context.Background(), reconcileOptions{prime: true})
+	if decisions[0].Reason != ReasonIdle {
+		t.Fatalf("want: %s, got: %s", ReasonIdle, decisions[0].Reason)
+	}
+
+	// woken, the first request took 40s
+	seconds = functionSeconds{Sum: 50, Count: 11}
+
+	decisions = controller.reconcile(context.Background(), reconcileOptions{})
+	coldStart := decisions[0].ColdStart
+	if coldStart == nil {
+		t.Fatalf("want cold start figures")
+	}
+	if time.Duration(coldStart.Duration) != 40*time.Second || coldStart.Source != coldStartMeasured {
+		t.Errorf("want a measured cold start of 40s, got: %+v", coldStart)
+	}
+	if time.Duration(coldStart.MinimumGap) != 2*time.Minute {
+		t.Errorf("want minimum gap: 2m0s, got: %s", time.Duration(coldStart.MinimumGap))
+	}
+
+	if function, _ := controller.state.function("figlet"); function.ColdStart == nil {
+		t.Errorf("want cold start figures in the status")
+	}
+}
+
+func Test_ControllerColdStartIgnoresWarmRequests(t *testing.T) {
+	scaler := newMemoryScaler(labelled("figlet", 1))
+	controller := newTestController(scaler, &fakeInvocations{counts: map[string]float64{}})
+	controller.config.ColdStartFactor = 3
+
+	seconds := functionSeconds{Sum: 1, Count: 10, Buckets: map[float64]float64{1: 10, 10: 10, 60: 10, math.Inf(1): 10}}
+	controller.functionSeconds = func(ctx context.Context, name string) (functionSeconds, error) {
+		return seconds, nil
+	}
+
+	decisions := controller.reconcile(context.Background(), reconcileOptions{prime: true})
+	if decisions[0].Reason != ReasonIdle {
+		t.Fatalf("want: %s, got: %s", ReasonIdle, decisions[0].Reason)
+	}
+
+	// woken by a request which took 40s, then 99 warm ones of 0.1s before
+	// the next poll
+	seconds = functionSeconds{Sum: 1 + 40 + 9.9, Count: 110, Buckets: map[float64]float64{1: 109, 10: 109, 60: 110, math.Inf(1): 110}}
+
+	decisions = controller.reconcile(context.Background(), reconcileOptions{})
+	coldStart := decisions[0].ColdStart
+	if coldStart == nil {
+		t.Fatalf("want cold start figures")
+	}
+	if time.Duration(coldStart.Duration) != time.Minute {
+		t.Errorf("want a cold start up to the 60s bucket, got: %s", time.Duration(coldStart.Duration))
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/openfaas-incubator/faas-idler/types"
+)
+
+const usage = `Usage: faas-idler [command] [flags]
+
+Commands:
+  run     reconcile continuously, scaling idle functions to zero (default)
+  once    run a single reconcile cycle then exit, i.e. from a CronJob
+  plan    show which functions would be scaled without scaling them
+  status  show the state of a running idler from its HTTP API
+
+Run "faas-idler [command] -h" for the flags of each command.
+`
+
+func runCommand(args []string) error {
+	var planFile string
+
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	flags.BoolVar(&dryRun, "dry-run", false, "use dry-run for scaling events")
+	flags.StringVar(&planFile, "plan-file", "", "file to append dry-run decisions to as JSON lines, stdout if empty")
+	flags.Parse(args)
+
+	controller, credentials, err := setup(true)
+	if err != nil {
+		return err
+	}
+	config := controller.config
+	defer flush(config.ShutdownTimeout)
+
+	opts := reconcileOptions{}
+	if dryRun {
+		if opts.plan, err = newPlanRecorder(planFile); err != nil {
+			return err
+		}
+		defer opts.plan.Close()
+	}
+
+	probeClient := &http.Client{Timeout: 5 * time.Second}
+	p := &probes{
+		ready: func(ctx context.Context) error {
+			return checkReady(ctx, probeClient, config.GatewayURL, gatewayMetricsURL, credentials)
+		},
+		deadline: livenessDeadline(config),
+		started:  time.Now(),
+		state:    controller.state,
+		leader:   controller.elector.IsLeader,
+	}
+
+	admin := &adminAPI{
+		scaler:      controller.scaler,
+		credentials: credentials,
+		state:       controller.state,
+		leader:      controller.elector.IsLeader,
+	}
+	if admin.token, err = readFile(path.Join(secretMountPath(), "admin-token")); err != nil {
+		log.Warn("unable to read admin token", "err", err)
+	}
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", config.HTTPPort),
+		Handler: newHandler(p, admin),
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error("unable to serve HTTP API", "addr", server.Addr, "err", err)
+			os.Exit(1)
+		}
+	}()
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	controller.elector.Run(ctx, func(ctx context.Context) {
+		p.setLeading(time.Now())
+		controller.Run(ctx, opts)
+	})
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer shutdownCancel()
+	return server.Shutdown(shutdownCtx)
+}
+
+// signalContext is cancelled on SIGTERM or SIGINT
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		defer signal.Stop(signals)
+
+		select {
+		case sig := <-signals:
+			log.Info("shutting down", "signal", sig.String())
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// flush waits up to timeout for queued events and audit records to be
+// written before the idler exits
+func flush(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		notifications.Close()
+		audit.Close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warn("events and audit records not flushed within the shutdown timeout", "timeout", timeout)
+	}
+}
+
+// evaluationRequests is the most requests to the gateway, its metrics and
+// Prometheus made for a function before or after the inactivity duration
+const evaluationRequests = 7
+
+// livenessDeadline allows for the longest a cycle can go without moving:
+// the inactivity duration, which every function waits out together, then
+// the requests for a single function when each takes its timeout and
+// retries and its scale-down waits for the rate limit, plus the configured
+// number of intervals.
+func livenessDeadline(config types.Config) time.Duration {
+	request := time.Duration(config.GatewayRetries+1) * config.GatewayTimeout
+	for attempt := 0; attempt < config.GatewayRetries; attempt++ {
+		request += gatewayBackoff << uint(attempt)
+	}
+
+	deadline := config.InactivityDuration + evaluationRequests*request
+	if config.ScaleRateLimit > 0 {
+		deadline += time.Minute / time.Duration(config.ScaleRateLimit)
+	}
+	return deadline + time.Duration(config.LivenessThreshold)*config.ReconcileInterval
+}
+
+func onceCommand(args []string) error {
+	var planFile string
+
+	flags := flag.NewFlagSet("once", flag.ExitOnError)
+	flags.BoolVar(&dryRun, "dry-run", false, "use dry-run for scaling events")
+	flags.StringVar(&planFile, "plan-file", "", "file to append dry-run decisions to as JSON lines, stdout if empty")
+	flags.Parse(args)
+
+	controller, _, err := setup(true)
+	if err != nil {
+		return err
+	}
+	defer flush(controller.config.ShutdownTimeout)
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	opts := reconcileOptions{prime: true}
+	if dryRun {
+		if opts.plan, err = newPlanRecorder(planFile); err != nil {
+			return err
+		}
+		defer opts.plan.Close()
+	}
+
+	controller.cycle(ctx, opts)
+	return nil
+}
+
+func planCommand(args []string) error {
+	var output, planFile string
+
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	flags.StringVar(&output, "o", "table", "output format: table or json, one decision per line")
+	flags.StringVar(&planFile, "plan-file", "", "file to write the plan to, stdout if empty")
+	flags.Parse(args)
+
+	if output != "table" && output != "json" {
+		return fmt.Errorf("unknown output format: %s", output)
+	}
+
+	// A plan never scales, whatever the flags say.
+	dryRun = true
+
+	controller, _, err := setup(false)
+	if err != nil {
+		return err
+	}
+	defer flush(controller.config.ShutdownTimeout)
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	if output == "json" {
+		plan, err := newPlanRecorder(planFile)
+		if err != nil {
+			return err
+		}
+		defer plan.Close()
+
+		controller.cycle(ctx, reconcileOptions{prime: true, plan: plan})
+		return nil
+	}
+
+	out := io.Writer(os.Stdout)
+	if len(planFile) > 0 && planFile != "-" {
+		file, err := os.Create(planFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	decisions := controller.cycle(ctx, reconcileOptions{prime: true})
+	return printDecisions(out, decisions)
+}
+
+func statusCommand(args []string) error {
+	var idlerURL, output string
+
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	flags.StringVar(&idlerURL, "url", "http://127.0.0.1:8080", "URL of the running faas-idler")
+	flags.StringVar(&output, "o", "table", "output format: table or json")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: faas-idler status [flags] [[namespace/]function]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	statusURL := strings.TrimSuffix(idlerURL, "/") + "/status"
+	if flags.NArg() > 0 {
+		statusURL = statusURL + "/" + flags.Arg(0)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	res, err := client.Get(statusURL)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	bytesOut, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code from faas-idler: %d, body: %s", res.StatusCode, strings.TrimSpace(string(bytesOut)))
+	}
+
+	if output == "json" {
+		_, err = os.Stdout.Write(bytesOut)
+		return err
+	}
+
+	functions := []FunctionState{}
+	if flags.NArg() > 0 {
+		function := FunctionState{}
+		if err := json.Unmarshal(bytesOut, &function); err != nil {
+			return err
+		}
+		functions = append(functions, function)
+	} else if err := json.Unmarshal(bytesOut, &functions); err != nil {
+		return err
+	}
+
+	return printFunctions(os.Stdout, functions)
+}
+
+func printFunctions(out io.Writer, functions []FunctionState) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FUNCTION\tNAMESPACE\tREPLICAS\tLAST COUNT\tLAST ACTIVITY\tIDLE IN\tACTION\tREASON")
+	for _, function := range functions {
+		lastActivity, idleIn, action, reason := "-", "-", "-", "-"
+		if function.LastActivity != nil {
+			lastActivity = function.LastActivity.Format(time.RFC3339)
+		}
+		if function.TimeUntilIdle != nil {
+			idleIn = time.Duration(*function.TimeUntilIdle).Round(time.Second).String()
+		}
+		if function.LastDecision != nil {
+			action = function.LastDecision.Action
+			reason = string(function.LastDecision.Reason)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.0f\t%s\t%s\t%s\t%s\n", function.Name, function.Namespace, function.Replicas, function.LastCount, lastActivity, idleIn, action, reason)
+	}
+	return w.Flush()
+}
+
+func printDecisions(out io.Writer, decisions []Decision) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FUNCTION\tNAMESPACE\tREPLICAS\tLAST ACTIVITY\tACTION\tREASON")
+	for _, decision := range decisions {
+		lastActivity := "-"
+		if decision.LastActivity != nil {
+			lastActivity = decision.LastActivity.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n", decision.Function, decision.Namespace, decision.AvailableReplicas, lastActivity, decision.Action, decision.Reason)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,622 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/openfaas-incubator/faas-idler/logger"
+	"github.com/openfaas-incubator/faas-idler/types"
+
+	providerTypes "github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/faas/gateway/metrics"
+)
+
+// errCounterChanged is returned instead of scaling a function which was
+// invoked while its scale request waited for a slot or the rate limit
+var errCounterChanged = errors.New("invocations seen while waiting to scale")
+
+// invocationsUnknownError is returned instead of scaling a function whose
+// invocations can't be read again once its scale request gets its turn
+type invocationsUnknownError struct {
+	err error
+}
+
+func (e invocationsUnknownError) Error() string {
+	return e.err.Error()
+}
+
+// Controller runs reconcile cycles, listing functions through its lister
+// and reading and scaling their replicas through its Scaler
+type Controller struct {
+	config types.Config
+	lister FunctionLister
+	scaler Scaler
+
+	// invocations returns the total invocations of a function and when
+	// they were sampled
+	invocations func(ctx context.Context, name string) (float64, time.Time, error)
+
+	// totalInvocations returns the invocations of every function, checked
+	// before each cycle when set
+	totalInvocations func(ctx context.Context) (float64, error)
+
+	// invocationsSeen is set once totalInvocations has counted any
+	invocationsSeen bool
+
+	// functionSeconds returns the request durations of a function, to
+	// measure its cold start by
+	functionSeconds func(ctx context.Context, name string) (functionSeconds, error)
+
+	// metricsAge returns how long ago Prometheus scraped the gateway,
+	// checked before each scale-down when set
+	metricsAge func() (time.Duration, error)
+
+	state *idlerState
+
+	// scaleSlots bounds the scale requests in flight, when set
+	scaleSlots chan struct{}
+	scaleRate  *rateLimiter
+
+	// elector decides whether this idler runs, it always does when nil
+	elector *leaderElector
+
+	// shard is the part of the functions this idler evaluates, all of them
+	// when nil
+	shard *shardRing
+}
+
+func newController(config types.Config, lister FunctionLister, scaler Scaler) *Controller {
+	c := &Controller{
+		config:          config,
+		lister:          lister,
+		scaler:          scaler,
+		invocations:     gatewayFunctionInvocationTotal,
+		functionSeconds: gatewayFunctionSeconds,
+		state:           state,
+		scaleRate:       newRateLimiter(config.ScaleRateLimit),
+		shard:           newShardRing(config.ShardIndex, config.ShardCount),
+	}
+
+	if config.MetricsSanityCheck {
+		c.totalInvocations = gatewayInvocationsTotal
+	}
+
+	if config.StalenessThreshold > 0 && len(config.GatewayJob) > 0 {
+		query := metrics.NewPrometheusQuery(config.PrometheusHost, config.PrometheusPort, metricsClient)
+		c.metricsAge = func() (time.Duration, error) {
+			return prometheusMetricsAge(query, config.GatewayJob, time.Now())
+		}
+	}
+
+	if config.MaxConcurrentScales > 0 {
+		c.scaleSlots = make(chan struct{}, config.MaxConcurrentScales)
+	}
+	return c
+}
+
+// reconcileOptions changes how a single reconcile cycle behaves
+type reconcileOptions struct {
+	// prime evaluates functions seen for the first time in the same cycle
+	// instead of only caching their counter, so that one-shot commands
+	// reach a decision without a previous cycle.
+	prime bool
+
+	// plan receives a record of every decision, if set
+	plan *planRecorder
+
+	// stop ends waits for the inactivity duration early, when shutting
+	// down. Functions whose wait is cut short are left as they are.
+	stop <-chan struct{}
+
+	// budget limits the functions scaled to zero, set by reconcile
+	budget *idleBudget
+}
+
+// Run reconciles every ReconcileInterval until ctx is cancelled, a cycle
+// which takes longer than the interval is followed straight away by the
+// next.
+func (c *Controller) Run(ctx context.Context, opts reconcileOptions) {
+	ticker := time.NewTicker(c.config.ReconcileInterval)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		c.cycle(ctx, opts)
+
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+}
+
+// cycle runs a single reconcile. When ctx is cancelled the cycle stops
+// waiting on inactivity, and requests already in flight are given
+// ShutdownTimeout to complete before they are cancelled too, unless
+// leadership was lost, when they are cancelled straight away.
+func (c *Controller) cycle(ctx context.Context, opts reconcileOptions) []Decision {
+	work, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-work.Done():
+			return
+		}
+
+		if !c.elector.IsLeader() {
+			log.Warn("lost leadership, cancelling the cycle")
+			cancel()
+			return
+		}
+
+		timer := time.NewTimer(c.config.ShutdownTimeout)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			log.Warn("cycle did not finish within the shutdown timeout, cancelling", "timeout", c.config.ShutdownTimeout)
+			cancel()
+		case <-work.Done():
+		}
+	}()
+
+	opts.stop = ctx.Done()
+	return c.reconcile(work, opts)
+}
+
+// wait sleeps for the inactivity duration, or returns false when stopped
+// first
+func (c *Controller) wait(stop <-chan struct{}) bool {
+	timer := time.NewTimer(c.config.InactivityDuration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// reconcile evaluates every function once, scaling those which are idle
+func (c *Controller) reconcile(ctx context.Context, opts reconcileOptions) []Decision {
+	cycleLog := log.With("cycle", atomic.AddUint64(&cycles, 1))
+	start := time.Now()
+
+	for _, exemption := range c.state.exemptions.Expire(start) {
+		cycleLog.Info("exemption expired", "pattern", exemption.Pattern, "source", exemption.Source)
+	}
+
+	functions, err := c.lister.ListFunctions(ctx)
+
+	if err != nil {
+		cycleLog.Warn("unable to list functions", "err", err)
+		return nil
+	}
+
+	functions = c.shard.filter(functions)
+	shardFunctions.Set(float64(len(functions)))
+
+	if reason, err := c.checkMetricsSource(ctx); len(reason) > 0 {
+		cycleLog.Warn("aborting cycle, invocation metrics can't be trusted", "reason", reason, "err", err)
+		cyclesAbortedTotal.WithLabelValues(reason).Inc()
+
+		// the loop is still running, so an aborted cycle counts for liveness
+		c.state.setLastCycle(time.Now())
+		return nil
+	}
+
+	eligible := 0
+	for _, function := range functions {
+		if optedIn(function) {
+			eligible++
+		}
+	}
+	opts.budget = newIdleBudget(c.config.MaxIdlePercent, c.config.MaxIdleFunctions, eligible)
+
+	evaluations := make([]*evaluation, len(functions))
+	for i, function := range functions {
+		evaluations[i] = &evaluation{
+			function: function,
+			log:      cycleLog.With("function", function.Name, "namespace", function.Namespace),
+		}
+	}
+
+	c.each(evaluations, func(e *evaluation) {
+		c.prepare(ctx, e, opts)
+	})
+
+	// every function waits out the same inactivity duration, without
+	// holding a worker
+	completed := true
+	for _, e := range evaluations {
+		if !e.done {
+			completed = c.wait(opts.stop)
+			c.state.setProgress(time.Now())
+			break
+		}
+	}
+
+	c.each(evaluations, func(e *evaluation) {
+		if !e.done {
+			c.finish(ctx, e, completed, opts)
+		}
+		c.record(e.decision, e.log, opts)
+	})
+
+	c.state.setLastCycle(time.Now())
+	reconcileCyclesTotal.Inc()
+	reconcileDuration.Observe(time.Since(start).Seconds())
+
+	results := make([]Decision, 0, len(evaluations))
+	for _, e := range evaluations {
+		results = append(results, e.decision)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Function < results[j].Function
+	})
+
+	return results
+}
+
+// evaluation is a function part way through a reconcile cycle
+type evaluation struct {
+	function providerTypes.FunctionStatus
+	log      *logger.Logger
+	decision Decision
+
+	// done is set once the decision is final before the wait
+	done bool
+
+	// idle is set when the function has replicas and its invocations are
+	// read again after the wait
+	idle       bool
+	replicas   uint64
+	window     time.Duration
+	lastCount  float64
+	firstCheck float64
+}
+
+// each runs fn for every evaluation on a pool of MaxConcurrentEvaluations
+// workers, or one worker each when unset, so that thousands of functions
+// don't flood the gateway and metrics source with requests
+func (c *Controller) each(evaluations []*evaluation, fn func(e *evaluation)) {
+	workers := c.config.MaxConcurrentEvaluations
+	if workers <= 0 || workers > len(evaluations) {
+		workers = len(evaluations)
+	}
+
+	queue := make(chan *evaluation, len(evaluations))
+	for _, e := range evaluations {
+		queue <- e
+	}
+	close(queue)
+	evaluationQueueDepth.Set(float64(len(evaluations)))
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for e := range queue {
+				evaluationQueueDepth.Dec()
+				fn(e)
+				c.state.setProgress(time.Now())
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// record keeps, exports and emits a decision
+func (c *Controller) record(decision Decision, log *logger.Logger, opts reconcileOptions) {
+	c.state.setDecision(decision)
+	functionsEvaluatedTotal.WithLabelValues(decision.Action, string(decision.Reason)).Inc()
+	log.Info("evaluated function", "decision", decision.Action, "reason", decision.Reason)
+
+	notifications.Emit(eventFor(decision))
+
+	if opts.plan != nil {
+		if err := opts.plan.Record(decision); err != nil {
+			log.Warn("unable to record plan", "err", err)
+		}
+	}
+}
+
+// prepare reads the replicas of a function and its invocations before the
+// inactivity duration, deciding straight away on those which can't be idle
+func (c *Controller) prepare(ctx context.Context, e *evaluation, opts reconcileOptions) {
+	function, log := e.function, e.log
+	decision := &e.decision
+	*decision = Decision{
+		Time:      time.Now(),
+		Function:  function.Name,
+		Namespace: function.Namespace,
+		Replicas:  function.Replicas,
+		Action:    actionSkip,
+		DryRun:    dryRun,
+	}
+
+	// Criteria 1: skip thouse no lables
+	if function.Labels != nil {
+		labels := *function.Labels
+		labelValue := labels[scaleLabel]
+
+		if labelValue != "1" && labelValue != "true" {
+			log.Debug("skipping function without label", "label", scaleLabel)
+			decision.Reason = ReasonMissingLabel
+			e.done = true
+			return
+		}
+
+		decision.Policy = &Policy{
+			Label:              scaleLabel + "=" + labelValue,
+			InactivityDuration: Duration(c.config.InactivityDuration),
+		}
+	}
+
+	// generate initial map
+	lastCount, ok := c.state.touch(function.Name)
+	if !ok {
+		var err error
+		if lastCount, _, err = c.invocations(ctx, function.Name); err != nil {
+			log.Warn("unable to read invocations", "err", err)
+			decision.Reason = ReasonInvocationsUnknown
+			e.done = true
+			return
+		}
+		c.state.setTouch(function.Name, lastCount)
+		log.Info("cache initialised", "count", lastCount)
+
+		if !opts.prime {
+			decision.Reason = ReasonCacheInitialised
+			e.done = true
+			return
+		}
+	}
+
+	c.measureColdStart(ctx, function.Name, log)
+	decision.ColdStart = c.coldStart(function, time.Now(), log)
+
+	fn := Function{Name: function.Name, Namespace: function.Namespace}
+
+	val, err := c.scaler.GetReplicas(ctx, fn)
+	if err == nil {
+		c.detectFlap(function.Name, val.AvailableReplicas, time.Now(), log)
+	}
+
+	// a function woken soon after being idled must stay quiet for longer
+	e.window = c.config.InactivityDuration
+	if decision.Flapping = c.flapping(function.Name, time.Now()); decision.Flapping != nil {
+		e.window = time.Duration(decision.Flapping.InactivityDuration)
+		if decision.Policy != nil {
+			decision.Policy.InactivityDuration = decision.Flapping.InactivityDuration
+		}
+	}
+
+	if err != nil {
+		log.Warn("unable to get replicas", "err", err)
+		decision.Reason = ReasonReplicasUnknown
+		if err == errCircuitOpen {
+			decision.Reason = ReasonCircuitOpen
+		}
+		return
+	}
+
+	if val.AvailableReplicas == 0 {
+		decision.Reason = ReasonNoAvailableReplicas
+		return
+	}
+	decision.AvailableReplicas = val.AvailableReplicas
+
+	firstCheck, _, err := c.invocations(ctx, function.Name)
+	if err != nil {
+		log.Warn("unable to read invocations", "err", err)
+		decision.Reason = ReasonInvocationsUnknown
+		e.done = true
+		return
+	}
+
+	e.idle = true
+	e.replicas = val.AvailableReplicas
+	e.lastCount = lastCount
+	e.firstCheck = firstCheck
+}
+
+// finish reads the invocations of a function again after the inactivity
+// duration, scaling it to zero when they haven't moved, and caches the
+// latest counter. completed is false when the wait was cut short.
+func (c *Controller) finish(ctx context.Context, e *evaluation, completed bool, opts reconcileOptions) {
+	function, log := e.function, e.log
+	decision := &e.decision
+
+	if e.idle {
+		if !completed {
+			log.Info("shutting down, inactivity not checked")
+			decision.Reason = ReasonInterrupted
+			return
+		}
+
+		secondCheck, sampled, err := c.invocations(ctx, function.Name)
+		if err != nil {
+			log.Warn("unable to read invocations", "err", err)
+			decision.Reason = ReasonInvocationsUnknown
+			return
+		}
+		log.Debug("checked invocations", "cached", e.lastCount, "first", e.firstCheck, "second", secondCheck)
+
+		decision.Counters = &Counters{
+			Cached: e.lastCount,
+			First:  e.firstCheck,
+			Second: secondCheck,
+		}
+
+		if secondCheck == e.firstCheck && secondCheck == e.lastCount {
+			// Idles InactivityDuration, scales to zero
+			if c.state.isPaused(time.Now()) {
+				decision.Reason = ReasonPaused
+			} else if until, ok := c.exemptUntil(function, time.Now(), log); ok {
+				decision.Reason = ReasonExempt
+				decision.ExemptUntil = &until
+			} else if decision.Flapping = c.flapping(function.Name, time.Now()); decision.Flapping.tooSoon() {
+				log.Info("not scaling function, it flapped and its inactivity duration was extended",
+					"quiet_for", time.Duration(decision.Flapping.QuietFor), "inactivity_duration", e.window)
+				decision.Reason = ReasonFlapping
+			} else if decision.ColdStart = c.coldStart(function, time.Now(), log); decision.ColdStart.tooCostly() {
+				log.Info("not scaling function, expected to be called again before its cold start pays off",
+					"expected_gap", time.Duration(decision.ColdStart.ExpectedGap), "cold_start", time.Duration(decision.ColdStart.Duration))
+				decision.Reason = ReasonColdStart
+			} else if c.stale(sampled, log) {
+				decision.Reason = ReasonStaleMetrics
+			} else if !opts.budget.take() {
+				log.Warn("not scaling function, blast radius limit reached for this cycle")
+				decision.Reason = ReasonBlastRadius
+			} else if err := c.scale(ctx, AuditRecord{
+				Function:         function.Name,
+				Namespace:        function.Namespace,
+				PreviousReplicas: &e.replicas,
+				Replicas:         0,
+				Reason:           string(ReasonIdle),
+				Evidence: &Evidence{
+					Counters: decision.Counters,
+					Window:   Duration(e.window),
+				},
+			}); err != nil {
+				opts.budget.refund()
+				if _, ok := err.(invocationsUnknownError); ok {
+					log.Warn("unable to read invocations", "err", err)
+					decision.Reason = ReasonInvocationsUnknown
+				} else if err == errCounterChanged {
+					log.Info("not scaling function, invoked while waiting to scale")
+					decision.Reason = ReasonCounterChanged
+				} else if err == errNotLeader {
+					log.Info("not scaling function, leadership was lost")
+					decision.Reason = ReasonInterrupted
+				} else {
+					log.Warn("unable to scale function", "err", err)
+					decision.Reason = ReasonScaleFailed
+					if err == errCircuitOpen {
+						decision.Reason = ReasonCircuitOpen
+					}
+				}
+			} else {
+				decision.Action = actionScale
+				decision.Reason = ReasonIdle
+
+				if !dryRun {
+					c.state.setIdled(function.Name, time.Now())
+					c.recordWakeBaseline(ctx, function.Name, log)
+				}
+			}
+		} else {
+			decision.Reason = ReasonCounterChanged
+		}
+	}
+
+	// update cache with latest check value
+	if count, _, err := c.invocations(ctx, function.Name); err == nil {
+		c.state.setTouch(function.Name, count)
+	} else {
+		log.Warn("unable to read invocations", "err", err)
+	}
+
+	if lastActivity, ok := c.state.lastActivity(function.Name); ok {
+		decision.LastActivity = &lastActivity
+	}
+}
+
+// stale reports whether the invocation counters sampled at sampled, or the
+// gateway's last scrape by Prometheus when checked, are older than the
+// staleness threshold, or their age can't be told
+func (c *Controller) stale(sampled time.Time, log *logger.Logger) bool {
+	if c.config.StalenessThreshold <= 0 {
+		return false
+	}
+
+	age := time.Since(sampled)
+	if c.metricsAge != nil {
+		scraped, err := c.metricsAge()
+		if err != nil {
+			log.Warn("not scaling function, unable to tell the age of the invocation metrics", "err", err)
+			return true
+		}
+		if scraped > age {
+			age = scraped
+		}
+	}
+
+	metricsAgeSeconds.Set(age.Seconds())
+	if age > c.config.StalenessThreshold {
+		log.Warn("not scaling function, invocation metrics are stale", "age", age, "threshold", c.config.StalenessThreshold)
+		return true
+	}
+	return false
+}
+
+// scale sends a scale request once a slot is free and the scale rate
+// limit allows it, while still the leader, and the invocations of the
+// function haven't moved from the counters it was idled on in the meantime
+func (c *Controller) scale(ctx context.Context, record AuditRecord) error {
+	scaleQueueDepth.Inc()
+	queued := true
+	defer func() {
+		if queued {
+			scaleQueueDepth.Dec()
+		}
+	}()
+
+	if c.scaleSlots != nil {
+		select {
+		case c.scaleSlots <- struct{}{}:
+			defer func() { <-c.scaleSlots }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if err := c.scaleRate.Wait(ctx); err != nil {
+		return err
+	}
+
+	scaleQueueDepth.Dec()
+	queued = false
+
+	if !c.elector.IsLeader() {
+		return errNotLeader
+	}
+
+	if record.Evidence != nil && record.Evidence.Counters != nil {
+		count, _, err := c.invocations(ctx, record.Function)
+		if err != nil {
+			return invocationsUnknownError{err}
+		}
+		if count != record.Evidence.Counters.Second {
+			return errCounterChanged
+		}
+	}
+
+	return scaleAudited(ctx, c.scaler, record)
+}
+
+// exemptUntil returns when the exemption of a function from idling ends,
+// set through the exemption store or its annotation
+func (c *Controller) exemptUntil(function providerTypes.FunctionStatus, now time.Time, log *logger.Logger) (time.Time, bool) {
+	if exemption, ok := c.state.exemptions.Match(function.Name, now); ok {
+		return exemption.Until, true
+	}
+
+	until, ok, err := annotationExemption(function.Annotations, now)
+	if err != nil {
+		log.Warn("ignoring invalid annotation", "annotation", exemptUntilAnnotation, "err", err)
+	}
+	return until, ok
+}
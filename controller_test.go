@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openfaas-incubator/faas-idler/types"
+	providerTypes "github.com/openfaas/faas-provider/types"
+)
+
+// fakeInvocations returns a fixed count for each function, which moves on
+// each read for the functions in busy, or fails for those in broken. Counts
+// are sampled when read, or age before that.
+type fakeInvocations struct {
+	lock   sync.Mutex
+	counts map[string]float64
+	busy   map[string]bool
+	broken map[string]bool
+	age    time.Duration
+}
+
+func (f *fakeInvocations) total(ctx context.Context, name string) (float64, time.Time, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.broken[name] {
+		return 0, time.Time{}, fmt.Errorf("unable to get metrics")
+	}
+	if f.busy[name] {
+		f.counts[name]++
+	}
+	return f.counts[name], time.Now().Add(-f.age), nil
+}
+
+func newTestController(scaler *memoryScaler, invocations *fakeInvocations) *Controller {
+	config := types.Config{InactivityDuration: time.Millisecond}
+
+	c := newController(config, scaler, scaler)
+	c.invocations = invocations.total
+	c.state = newIdlerState()
+	return c
+}
+
+func labelled(name string, replicas uint64) providerTypes.FunctionStatus {
+	labels := map[string]string{scaleLabel: "true"}
+	return providerTypes.FunctionStatus{
+		Name:              name,
+		Namespace:         "openfaas-fn",
+		Replicas:          replicas,
+		AvailableReplicas: replicas,
+		Labels:            &labels,
+	}
+}
+
+func Test_ControllerIdlesInactiveFunctions(t *testing.T) {
+	unlabelled := providerTypes.FunctionStatus{Name: "nodeinfo", Replicas: 1, AvailableReplicas: 1, Labels: &map[string]string{}}
+	scaler := newMemoryScaler(labelled("figlet", 1), labelled("busy", 1), labelled("stopped", 0), unlabelled)
+
+	invocations := &fakeInvocations{
+		counts: map[string]float64{"figlet": 3, "busy": 3},
+		busy:   map[string]bool{"busy": true},
+	}
+	controller := newTestController(scaler, invocations)
+
+	decisions := controller.reconcile(context.Background(), reconcileOptions{prime: true})
+
+	want := map[string]Reason{
+		"busy":     ReasonCounterChanged,
+		"figlet":   ReasonIdle,
+		"nodeinfo": ReasonMissingLabel,
+		"stopped":  ReasonNoAvailableReplicas,
+	}
+	if len(decisions) != len(want) {
+		t.Fatalf("want %d decisions, got: %d", len(want), len(decisions))
+	}
+	for _, decision := range decisions {
+		if decision.Reason != want[decision.Function] {
+			t.Errorf("want %s: %s, got: %s", decision.Function, want[decision.Function], decision.Reason)
+		}
+	}
+
+	calls := scaler.Calls()
+	if len(calls) != 1 || calls[0].Function.Name != "figlet" || calls[0].Replicas != 0 {
+		t.Errorf("want only figlet scaled to 0, got: %v", calls)
+	}
+}
+
+func Test_ControllerCachesBeforeIdling(t *testing.T) {
+	scaler := newMemoryScaler(labelled("figlet", 1))
+	controller := newTestController(scaler, &fakeInvocations{counts: map[string]float64{}})
+
+	decisions := controller.reconcile(context.Background(), reconcileOptions{})
+	if decisions[0].Reason != ReasonCacheInitialised {
+		t.Fatalf("want first cycle: %s, got: %s", ReasonCacheInitialised, decisions[0].Reason)
+	}
+	if len(scaler.Calls()) != 0 {
+		t.Errorf("want no scale requests in the first cycle")
+	}
+
+	decisions = controller.reconcile(context.Background(), reconcileOptions{})
+	if decisions[0].Reason != ReasonIdle {
+		t.Errorf("want second cycle: %s, got: %s", ReasonIdle, decisions[0].Reason)
+	}
+}
+
+func Test_ControllerScaleFailed(t *testing.T) {
+	scaler := newMemoryScaler(labelled("figlet", 1))
+	scaler.scaleErr = fmt.Errorf("gateway unavailable")
+	controller := newTestController(scaler, &fakeInvocations{counts: map[string]float64{}})
+
+	decisions := controller.reconcile(context.Background(), reconcileOptions{prime: true})
+	if decisions[0].Reason != ReasonScaleFailed {
+		t.Errorf("want: %s, got: %s", ReasonScaleFailed, decisions[0].Reason)
+	}
+}
+
+func Test_ControllerCircuitOpen(t *testing.T) {
+	scaler := newMemoryScaler(labelled("figlet", 1))
+	scaler.scaleErr = errCircuitOpen
+	controller := newTestController(scaler, &fakeInvocations{counts: map[string]float64{}})
+
+	decisions := controller.reconcile(context.Background(), reconcileOptions{prime: true})
+	if decisions[0].Reason != ReasonCircuitOpen {
+		t.Errorf("want: %s, got: %s", ReasonCircuitOpen, decisions[0].Reason)
+	}
+}
+
+func Test_ControllerRespectsPause(t *testing.T) {
+	scaler := newMemoryScaler(labelled("figlet", 1))
+	controller := newTestController(scaler, &fakeInvocations{counts: map[string]float64{}})
+	controller.state.pause(time.Time{})
+
+	decisions := controller.reconcile(context.Background(), reconcileOptions{prime: true})
+	if decisions[0].Reason != ReasonPaused {
+		t.Errorf("want: %s, got: %s", ReasonPaused, decisions[0].Reason)
+	}
+	if len(scaler.Calls()) != 0 {
+		t.Errorf("want no scale requests while paused")
+	}
+}
+
+func Test_ControllerRunStopsWhenCancelled(t *testing.T) {
+	scaler := newMemoryScaler(labelled("figlet", 1))
+	controller := newTestController(scaler, &fakeInvocations{counts: map[string]float64{}})
+	controller.config.ReconcileInterval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		controller.Run(ctx, reconcileOptions{})
+		close(done)
+	}()
+
+	// The first cycle caches the counter, the second idles figlet
+	deadline := time.After(5 * time.Second)
+	for len(scaler.Calls()) == 0 {
+		select {
+		case <-deadline:
+			t.Fatalf("want figlet idled by Run")
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("want Run to return once cancelled")
+	}
+}
+
+func Test_ControllerShutdownInterruptsWait(t *testing.T) {
+	scaler := newMemoryScaler(labelled("figlet", 1))
+	controller := newTestController(scaler, &fakeInvocations{counts: map[string]float64{}})
+	controller.config.InactivityDuration = time.Hour
+	controller.config.ShutdownTimeout = time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	start := time.Now()
+	decisions := controller.cycle(ctx, reconcileOptions{prime: true})
+
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("want cycle to stop waiting at shutdown, took: %s", time.Since(start))
+	}
+	if decisions[0].Reason != ReasonInterrupted {
+		t.Errorf("want: %s, got: %s", ReasonInterrupted, decisions[0].Reason)
+	}
+	if len(scaler.Calls()) != 0 {
+		t.Errorf("want no scale requests after an interrupted wait")
+	}
+}
+
+// blockingScaler blocks scale requests until their context is cancelled
+type blockingScaler struct {
+	*memoryScaler
+}
+
+func (b *blockingScaler) Scale(ctx context.Context, fn Function, replicas uint64) (scaleResponse, error) {
+	<-ctx.Done()
+	return scaleResponse{}, ctx.Err()
+}
+
+func Test_ControllerShutdownCancelsRequestsAfterTimeout(t *testing.T) {
+	memory := newMemoryScaler(labelled("figlet", 1))
+	controller := newTestController(memory, &fakeInvocations{counts: map[string]float64{}})
+	controller.scaler = &blockingScaler{memory}
+	controller.config.ShutdownTimeout = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	decisions := controller.cycle(ctx, reconcileOptions{prime: true})
+	if decisions[0].Reason != ReasonScaleFailed {
+		t.Errorf("want: %s, got: %s", ReasonScaleFailed, decisions[0].Reason)
+	}
+}
+
+// concurrencyScaler records the most reads and scale requests it has seen
+// in flight at once
+type concurrencyScaler struct {
+	*memoryScaler
+
+	lock       sync.Mutex
+	reads      int
+	scales     int
+	peakReads  int
+	peakScales int
+}
+
+func (s *concurrencyScaler) track(inFlight, peak *int) func() {
+	s.lock.Lock()
+	*inFlight++
+	if *inFlight > *peak {
+		*peak = *inFlight
+	}
+	s.lock.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	return func() {
+		s.lock.Lock()
+		*inFlight--
+		s.lock.Unlock()
+	}
+}
+
+func (s *concurrencyScaler) GetReplicas(ctx context.Context, fn Function) (*providerTypes.FunctionStatus, error) {
+	defer s.track(&s.reads, &s.peakReads)()
+	return s.memoryScaler.GetReplicas(ctx, fn)
+}
+
+func (s *concurrencyScaler) Scale(ctx context.Context, fn Function, replicas uint64) (scaleResponse, error) {
+	defer s.track(&s.scales, &s.peakScales)()
+	return s.memoryScaler.Scale(ctx, fn, replicas)
+}
+
+func Test_ControllerBoundsConcurrency(t *testing.T) {
+	memory := newMemoryScaler()
+	for i := 0; i < 8; i++ {
+		function := labelled(fmt.Sprintf("fn-%d", i), 1)
+		memory.functions[function.Name] = function
+	}
+
+	scaler := &concurrencyScaler{memoryScaler: memory}
+	config := types.Config{
+		InactivityDuration:       time.Millisecond,
+		MaxConcurrentEvaluations: 3,
+		MaxConcurrentScales:      1,
+	}
+
+	controller := newController(config, memory, scaler)
+	controller.invocations = (&fakeInvocations{counts: map[string]float64{}}).total
+	controller.state = newIdlerState()
+
+	decisions := controller.reconcile(context.Background(), reconcileOptions{prime: true})
+	if len(decisions) != 8 {
+		t.Fatalf("want 8 decisions, got: %d", len(decisions))
+	}
+	for _, decision := range decisions {
+		if decision.Reason != ReasonIdle {
+			t.Errorf("want %s: %s, got: %s", decision.Function, ReasonIdle, decision.Reason)
+		}
+	}
+
+	if scaler.peakReads > 3 {
+		t.Errorf("want at most 3 evaluations at once, got: %d", scaler.peakReads)
+	}
+	if scaler.peakScales != 1 {
+		t.Errorf("want 1 scale request at once, got: %d", scaler.peakScales)
+	}
+}
+
+func Test_ControllerWaitsOncePerCycle(t *testing.T) {
+	scaler := newMemoryScaler()
+	for i := 0; i < 6; i++ {
+		function := labelled(fmt.Sprintf("fn-%d", i), 1)
+		scaler.functions[function.Name] = function
+	}
+
+	config := types.Config{
+		InactivityDuration:       50 * time.Millisecond,
+		MaxConcurrentEvaluations: 1,
+	}
+	controller := newController(config, scaler, scaler)
+	controller.invocations = (&fakeInvocations{counts: map[string]float64{}}).total
+	controller.state = newIdlerState()
+
+	started := time.Now()
+	decisions := controller.reconcile(context.Background(), reconcileOptions{prime: true})
+	took := time.Since(started)
+
+	if len(decisions) != 6 {
+		t.Fatalf("want 6 decisions, got: %d", len(decisions))
+	}
+	// one worker waiting for each function in turn would take 300ms
+	if took >= 200*time.Millisecond {
+		t.Errorf("want a single wait for the whole cycle, got: %s", took)
+	}
+}
+
+func Test_ControllerScaleRateLimit(t *testing.T) {
+	scaler := newMemoryScaler(labelled("figlet", 1), labelled("nodeinfo", 1))
+	controller := newTestController(scaler, &fakeInvocations{counts: map[string]float64{}})
+	controller.scaleRate = newRateLimiter(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	decisions := controller.reconcile(ctx, reconcileOptions{prime: true})
+
+	scaled := 0
+	for _, decision := range decisions {
+		if decision.Action == actionScale {
+			scaled++
+		}
+	}
+	if scaled != 1 {
+		t.Errorf("want 1 function scaled within the rate limit, got: %d", scaled)
+	}
+}
+
+func Test_ControllerRechecksInvocationsBeforeScaling(t *testing.T) {
+	scaler := newMemoryScaler(labelled("figlet", 1))
+	invocations := &fakeInvocations{counts: map[string]float64{}}
+	controller := newTestController(scaler, invocations)
+	controller.scaleSlots = make(chan struct{}, 1)
+	controller.scaleSlots <- struct{}{}
+
+	go func() {
+		// figlet is invoked while its scale request waits for the slot
+		time.Sleep(20 * time.Millisecond)
+		invocations.lock.Lock()
+		invocations.counts["figlet"]++
+		invocations.lock.Unlock()
+		<-controller.scaleSlots
+	}()
+
+	decisions := controller.reconcile(context.Background(), reconcileOptions{prime: true})
+
+	if decisions[0].Reason != ReasonCounterChanged {
+		t.Errorf("want reason: %s, got: %s", ReasonCounterChanged, decisions[0].Reason)
+	}
+	if len(scaler.Calls()) != 0 {
+		t.Errorf("want no scale requests, got: %v", scaler.Calls())
+	}
+}
+
+func Test_ControllerUnreadableInvocationsBeforeScaling(t *testing.T) {
+	scaler := newMemoryScaler(labelled("figlet", 1))
+	invocations := &fakeInvocations{counts: map[string]float64{}, broken: map[string]bool{}}
+	controller := newTestController(scaler, invocations)
+	controller.scaleSlots = make(chan struct{}, 1)
+	controller.scaleSlots <- struct{}{}
+
+	go func() {
+		// the metrics go away while figlet's scale request waits for the slot
+		time.Sleep(20 * time.Millisecond)
+		invocations.lock.Lock()
+		invocations.broken["figlet"] = true
+		invocations.lock.Unlock()
+		<-controller.scaleSlots
+	}()
+
+	decisions := controller.reconcile(context.Background(), reconcileOptions{prime: true})
+
+	if decisions[0].Reason != ReasonInvocationsUnknown {
+		t.Errorf("want reason: %s, got: %s", ReasonInvocationsUnknown, decisions[0].Reason)
+	}
+	if len(scaler.Calls()) != 0 {
+		t.Errorf("want no scale requests, got: %v", scaler.Calls())
+	}
+}
+
+func Test_ControllerStopsScalingWhenLeadershipIsLost(t *testing.T) {
+	scaler := newMemoryScaler(labelled("figlet", 1))
+	controller := newTestController(scaler, &fakeInvocations{counts: map[string]float64{}})
+	controller.elector = &leaderElector{}
+
+	decisions := controller.reconcile(context.Background(), reconcileOptions{prime: true})
+
+	if decisions[0].Reason != ReasonInterrupted {
+		t.Errorf("want: %s, got: %s", ReasonInterrupted, decisions[0].Reason)
+	}
+	if len(scaler.Calls()) != 0 {
+		t.Errorf("want no scale requests once leadership is lost, got: %v", scaler.Calls())
+	}
+}
+
+func Test_ControllerLostLeadershipCancelsRequests(t *testing.T) {
+	memory := newMemoryScaler(labelled("figlet", 1))
+	controller := newTestController(memory, &fakeInvocations{counts: map[string]float64{}})
+	controller.scaler = &blockingScaler{memory}
+	controller.config.ShutdownTimeout = time.Hour
+	controller.elector = &leaderElector{}
+	controller.elector.setLeading(true)
+	defer controller.elector.setLeading(false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, func() {
+		controller.elector.setLeading(false)
+		cancel()
+	})
+
+	start := time.Now()
+	controller.cycle(ctx, reconcileOptions{prime: true})
+
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("want requests cancelled when leadership is lost, took: %s", time.Since(start))
+	}
+}
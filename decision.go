@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	actionScale = "scale"
+	actionSkip  = "skip"
+)
+
+// Reason explains the outcome of evaluating a function in a cycle
+type Reason string
+
+const (
+	// ReasonIdle the function was scaled to zero after no invocations
+	ReasonIdle Reason = "idle"
+	// ReasonMissingLabel the function has not opted into scale to zero
+	ReasonMissingLabel Reason = "missing-label"
+	// ReasonCacheInitialised the function was seen for the first time, its
+	// counter is compared from the next cycle on
+	ReasonCacheInitialised Reason = "cache-initialised"
+	// ReasonReplicasUnknown the replicas could not be read from the gateway
+	ReasonReplicasUnknown Reason = "replicas-unknown"
+	// ReasonInvocationsUnknown the invocations could not be read from the
+	// metrics source
+	ReasonInvocationsUnknown Reason = "invocations-unknown"
+	// ReasonNoAvailableReplicas the function has no replicas to scale down
+	ReasonNoAvailableReplicas Reason = "no-available-replicas"
+	// ReasonCounterChanged invocations were seen in the inactivity duration
+	ReasonCounterChanged Reason = "counter-changed"
+	// ReasonScaleFailed the function was idle but the scale request failed
+	ReasonScaleFailed Reason = "scale-failed"
+	// ReasonPaused the function was idle but idling is paused
+	ReasonPaused Reason = "paused"
+	// ReasonExempt the function was idle but is exempt from idling
+	ReasonExempt Reason = "exempt"
+	// ReasonInterrupted the idler shut down before the inactivity duration
+	// had passed, or lost leadership before scaling the function
+	ReasonInterrupted Reason = "interrupted"
+	// ReasonCircuitOpen the gateway kept failing, so calls to it are paused
+	ReasonCircuitOpen Reason = "circuit-open"
+	// ReasonBlastRadius the function was idle but the cycle had already
+	// scaled as many functions to zero as it may
+	ReasonBlastRadius Reason = "blast-radius"
+	// ReasonStaleMetrics the function was idle but the invocation metrics
+	// were too old to trust
+	ReasonStaleMetrics Reason = "stale-metrics"
+	// ReasonColdStart the function was idle but is expected to be called
+	// again too soon to be worth its cold start
+	ReasonColdStart Reason = "cold-start"
+	// ReasonFlapping the function was idle but keeps being woken soon after
+	// it is scaled to zero, so its inactivity duration was extended
+	ReasonFlapping Reason = "flapping"
+)
+
+// Decision is the outcome of evaluating a function during a reconcile cycle
+type Decision struct {
+	Time              time.Time  `json:"time"`
+	Function          string     `json:"function"`
+	Namespace         string     `json:"namespace,omitempty"`
+	Replicas          uint64     `json:"replicas"`
+	AvailableReplicas uint64     `json:"availableReplicas"`
+	Counters          *Counters  `json:"counters,omitempty"`
+	LastActivity      *time.Time `json:"lastActivity,omitempty"`
+	Policy            *Policy    `json:"policy,omitempty"`
+	ExemptUntil       *time.Time `json:"exemptUntil,omitempty"`
+	ColdStart         *ColdStart `json:"coldStart,omitempty"`
+	Flapping          *Flapping  `json:"flapping,omitempty"`
+	Action            string     `json:"action"`
+	Reason            Reason     `json:"reason"`
+	DryRun            bool       `json:"dryRun"`
+}
+
+// Counters are the invocation totals observed while evaluating a function,
+// it is only scaled when all three match.
+type Counters struct {
+	Cached float64 `json:"cached"`
+	First  float64 `json:"first"`
+	Second float64 `json:"second"`
+}
+
+// Policy is the idling rule a function matched
+type Policy struct {
+	Label              string   `json:"label"`
+	InactivityDuration Duration `json:"inactivityDuration"`
+}
+
+// Duration is a time.Duration written to JSON as a string, i.e. "5m0s"
+type Duration time.Duration
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON reads a duration written by MarshalJSON
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
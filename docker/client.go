@@ -0,0 +1,194 @@
+// Package docker is a minimal client for the Swarm services API of the
+// Docker Engine, reached over a unix socket or TCP.
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultHost is the Docker Engine's socket on a manager node
+const DefaultHost = "unix:///var/run/docker.sock"
+
+// Client calls the Docker Engine API
+type Client struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewClient returns a Client for host, given as unix:///path/to/socket or
+// tcp://host:port in the same way as DOCKER_HOST, or DefaultHost when empty
+func NewClient(host string) (*Client, error) {
+	if len(host) == 0 {
+		host = DefaultHost
+	}
+
+	parsed, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Docker host: %s", err)
+	}
+
+	transport := &http.Transport{}
+	baseURL := ""
+
+	switch parsed.Scheme {
+	case "unix":
+		socket := parsed.Path
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialer := net.Dialer{}
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		baseURL = "http://docker"
+	case "tcp", "http":
+		baseURL = "http://" + parsed.Host
+	default:
+		return nil, fmt.Errorf("unsupported Docker host: %s, use unix:// or tcp://", host)
+	}
+
+	return &Client{
+		baseURL: baseURL,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: transport,
+		},
+	}, nil
+}
+
+// ListServices returns the services with every label in labels, given as
+// key=value
+func (c *Client) ListServices(ctx context.Context, labels ...string) ([]Service, error) {
+	query := url.Values{}
+	if len(labels) > 0 {
+		filters, err := json.Marshal(map[string][]string{"label": labels})
+		if err != nil {
+			return nil, err
+		}
+		query.Set("filters", string(filters))
+	}
+
+	services := []Service{}
+	err := c.do(ctx, http.MethodGet, "/services?"+query.Encode(), nil, &services)
+	return services, err
+}
+
+// InspectService returns a service by its name or ID
+func (c *Client) InspectService(ctx context.Context, id string) (*Service, error) {
+	service := &Service{}
+	err := c.do(ctx, http.MethodGet, "/services/"+url.PathEscape(id), nil, service)
+	return service, err
+}
+
+// RunningTasks counts the running tasks of a service
+func (c *Client) RunningTasks(ctx context.Context, service string) (uint64, error) {
+	filters, err := json.Marshal(map[string][]string{
+		"service":       {service},
+		"desired-state": {"running"},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	tasks := []Task{}
+	if err := c.do(ctx, http.MethodGet, "/tasks?filters="+url.QueryEscape(string(filters)), nil, &tasks); err != nil {
+		return 0, err
+	}
+
+	var running uint64
+	for _, task := range tasks {
+		if task.Status.State == "running" {
+			running++
+		}
+	}
+	return running, nil
+}
+
+// ScaleService sets the replicas of a replicated service. The rest of its
+// spec is sent back unchanged, at the version it was read at, so that a
+// concurrent update is rejected rather than overwritten.
+func (c *Client) ScaleService(ctx context.Context, id string, replicas uint64) error {
+	service := &Service{}
+	if err := c.do(ctx, http.MethodGet, "/services/"+url.PathEscape(id), nil, service); err != nil {
+		return err
+	}
+
+	spec := map[string]interface{}{}
+	if err := json.Unmarshal(service.RawSpec, &spec); err != nil {
+		return err
+	}
+
+	mode, _ := spec["Mode"].(map[string]interface{})
+	replicated, ok := mode["Replicated"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("service %s is not replicated", service.Spec.Name)
+	}
+	replicated["Replicas"] = replicas
+
+	uri := fmt.Sprintf("/services/%s/update?version=%s", url.PathEscape(service.ID), strconv.FormatUint(service.Version.Index, 10))
+	return c.do(ctx, http.MethodPost, uri, spec, nil)
+}
+
+func (c *Client) do(ctx context.Context, method string, uri string, body interface{}, out interface{}) error {
+	reader := bytes.NewReader(nil)
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(bodyBytes)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+uri, reader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	resBytes, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return &StatusError{Code: res.StatusCode, Message: errorMessage(resBytes)}
+	}
+
+	if out != nil {
+		return json.Unmarshal(resBytes, out)
+	}
+	return nil
+}
+
+// errorMessage reads the message from an error response
+func errorMessage(body []byte) string {
+	msg := struct {
+		Message string `json:"message"`
+	}{}
+	if err := json.Unmarshal(body, &msg); err == nil && len(msg.Message) > 0 {
+		return msg.Message
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// StatusError is returned when the Docker Engine doesn't accept a request
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code from Docker: %d, %s", e.Code, e.Message)
+}
@@ -0,0 +1,161 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+const figletService = `{
+	"ID": "abc123",
+	"Version": {"Index": 42},
+	"Spec": {
+		"Name": "figlet",
+		"Labels": {"function": "true", "com.openfaas.scale.zero": "true"},
+		"Mode": {"Replicated": {"Replicas": 2}},
+		"TaskTemplate": {"ContainerSpec": {"Image": "functions/figlet:latest"}},
+		"EndpointSpec": {"Mode": "vip"}
+	}
+}`
+
+func newStubEngine(t *testing.T, updated *map[string]interface{}, updateURI *string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Query().Get("filters"), "function=true") {
+			t.Errorf("want services filtered by label, got: %s", r.URL.RawQuery)
+		}
+		w.Write([]byte("[" + figletService + "]"))
+	})
+	mux.HandleFunc("/services/figlet", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(figletService))
+	})
+	mux.HandleFunc("/services/abc123/update", func(w http.ResponseWriter, r *http.Request) {
+		*updateURI = r.URL.RequestURI()
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, updated); err != nil {
+			t.Errorf("update is not JSON: %s", err)
+		}
+		w.Write([]byte(`{}`))
+	})
+	mux.HandleFunc("/tasks", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"ID":"1","Status":{"State":"running"}},{"ID":"2","Status":{"State":"starting"}}]`))
+	})
+	return mux
+}
+
+func Test_ClientOverTCP(t *testing.T) {
+	updated := map[string]interface{}{}
+	var updateURI string
+
+	server := httptest.NewServer(newStubEngine(t, &updated, &updateURI))
+	defer server.Close()
+
+	client, err := NewClient("tcp://" + strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	services, err := client.ListServices(context.Background(), "function=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 || services[0].Spec.Name != "figlet" || *services[0].Spec.Mode.Replicated.Replicas != 2 {
+		t.Fatalf("want figlet with 2 replicas, got: %+v", services)
+	}
+
+	running, err := client.RunningTasks(context.Background(), "figlet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if running != 1 {
+		t.Errorf("want 1 running task, got: %d", running)
+	}
+
+	if err := client.ScaleService(context.Background(), "figlet", 0); err != nil {
+		t.Fatal(err)
+	}
+	if updateURI != "/services/abc123/update?version=42" {
+		t.Errorf("want update at version 42, got: %s", updateURI)
+	}
+
+	mode := updated["Mode"].(map[string]interface{})["Replicated"].(map[string]interface{})
+	if mode["Replicas"] != float64(0) {
+		t.Errorf("want replicas updated to 0, got: %v", mode["Replicas"])
+	}
+	if _, ok := updated["EndpointSpec"]; !ok {
+		t.Errorf("want the rest of the spec sent back unchanged, got: %v", updated)
+	}
+}
+
+func Test_ClientOverUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := path.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %s", err)
+	}
+
+	updated := map[string]interface{}{}
+	var updateURI string
+	server := &httptest.Server{Listener: listener, Config: &http.Server{Handler: newStubEngine(t, &updated, &updateURI)}}
+	server.Start()
+	defer server.Close()
+
+	client, err := NewClient("unix://" + socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service, err := client.InspectService(context.Background(), "figlet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.ID != "abc123" {
+		t.Errorf("want service abc123, got: %s", service.ID)
+	}
+}
+
+func Test_ClientErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"service missing not found"}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient("tcp://" + strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.InspectService(context.Background(), "missing")
+	statusErr, ok := err.(*StatusError)
+	if !ok || statusErr.Code != http.StatusNotFound || statusErr.Message != "service missing not found" {
+		t.Errorf("want not found StatusError, got: %v", err)
+	}
+
+	if _, err := NewClient("ssh://manager"); err == nil {
+		t.Errorf("want error for unsupported scheme")
+	}
+}
+
+func Test_NewClientDefaultHost(t *testing.T) {
+	client, err := NewClient("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.baseURL != "http://docker" {
+		t.Errorf("want the default unix socket, got: %s", client.baseURL)
+	}
+}
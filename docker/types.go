@@ -0,0 +1,76 @@
+package docker
+
+import "encoding/json"
+
+// Service is a Swarm service, RawSpec keeps the spec as it was read so
+// that it can be sent back on update without losing fields
+type Service struct {
+	ID      string          `json:"ID"`
+	Version Version         `json:"Version"`
+	Spec    ServiceSpec     `json:"Spec"`
+	RawSpec json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON reads the service and keeps its raw spec
+func (s *Service) UnmarshalJSON(data []byte) error {
+	type service Service
+	raw := struct {
+		service
+		Spec json.RawMessage `json:"Spec"`
+	}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*s = Service(raw.service)
+	s.RawSpec = raw.Spec
+	if len(raw.Spec) > 0 {
+		return json.Unmarshal(raw.Spec, &s.Spec)
+	}
+	return nil
+}
+
+// Version is the version of an object, needed to update it
+type Version struct {
+	Index uint64 `json:"Index"`
+}
+
+// ServiceSpec is the part of a service's spec the idler reads
+type ServiceSpec struct {
+	Name         string            `json:"Name"`
+	Labels       map[string]string `json:"Labels"`
+	Mode         ServiceMode       `json:"Mode"`
+	TaskTemplate TaskSpec          `json:"TaskTemplate"`
+}
+
+// ServiceMode is replicated or global
+type ServiceMode struct {
+	Replicated *ReplicatedService `json:"Replicated,omitempty"`
+}
+
+// ReplicatedService runs Replicas tasks
+type ReplicatedService struct {
+	Replicas *uint64 `json:"Replicas,omitempty"`
+}
+
+// TaskSpec is the template for a service's tasks
+type TaskSpec struct {
+	ContainerSpec ContainerSpec `json:"ContainerSpec"`
+}
+
+// ContainerSpec is the container a task runs
+type ContainerSpec struct {
+	Image  string            `json:"Image"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// Task is a replica of a service
+type Task struct {
+	ID     string     `json:"ID"`
+	Status TaskStatus `json:"Status"`
+}
+
+// TaskStatus is the observed state of a task
+type TaskStatus struct {
+	State string `json:"State"`
+}
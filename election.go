@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/openfaas-incubator/faas-idler/k8s"
+)
+
+const (
+	electionKubernetes = "kubernetes"
+	electionFile       = "file"
+)
+
+// errNotLeader is returned instead of scaling once leadership is lost, as
+// another idler may already be reconciling
+var errNotLeader = errors.New("no longer the leader")
+
+// LeaderLock is held by at most one idler at a time
+type LeaderLock interface {
+	// TryAcquire takes or renews the lock, returning false while another
+	// idler holds it
+	TryAcquire(ctx context.Context) (bool, error)
+
+	// Release gives up the lock so that another idler can take it straight
+	// away
+	Release(ctx context.Context) error
+}
+
+// leaderElector only runs the controller while its lock is held, so that
+// two or more idlers can run for availability without scaling twice. A nil
+// leaderElector always leads.
+type leaderElector struct {
+	lock     LeaderLock
+	identity string
+
+	// retryPeriod is how often the lock is tried and renewed
+	retryPeriod time.Duration
+
+	// renewDeadline is how long the leader keeps leading while the lock
+	// can't be renewed, it must be shorter than the lease
+	renewDeadline time.Duration
+
+	leading int32
+}
+
+func newLeaderElector(lock LeaderLock, identity string, leaseDuration time.Duration) *leaderElector {
+	return &leaderElector{
+		lock:          lock,
+		identity:      identity,
+		retryPeriod:   leaseDuration / 3,
+		renewDeadline: leaseDuration * 2 / 3,
+	}
+}
+
+// IsLeader reports whether this idler reconciles
+func (e *leaderElector) IsLeader() bool {
+	if e == nil {
+		return true
+	}
+	return atomic.LoadInt32(&e.leading) == 1
+}
+
+// Run calls lead with a context which is cancelled when leadership is lost,
+// and waits for it to return before trying to lead again, until ctx is
+// cancelled
+func (e *leaderElector) Run(ctx context.Context, lead func(ctx context.Context)) {
+	if e == nil {
+		lead(ctx)
+		return
+	}
+
+	log.Info("waiting for leadership", "identity", e.identity)
+
+	for ctx.Err() == nil {
+		if e.acquire(ctx) {
+			e.lead(ctx, lead)
+		}
+	}
+}
+
+// acquire tries the lock every retryPeriod, returning false when ctx is
+// cancelled first
+func (e *leaderElector) acquire(ctx context.Context) bool {
+	for {
+		acquired, err := e.lock.TryAcquire(ctx)
+		if err != nil {
+			log.Warn("unable to acquire leadership", "identity", e.identity, "err", err)
+		}
+		if acquired {
+			return true
+		}
+
+		if !sleep(ctx, e.retryPeriod) {
+			return false
+		}
+	}
+}
+
+// lead runs lead until ctx is cancelled or the lock is lost. When the lock
+// is lost, IsLeader is false before lead's context is cancelled, so that
+// its work stops straight away rather than finishing as at shutdown.
+func (e *leaderElector) lead(ctx context.Context, lead func(ctx context.Context)) {
+	e.setLeading(true)
+	log.Info("became leader", "identity", e.identity)
+
+	leadCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lead(leadCtx)
+	}()
+
+	renewed := time.Now()
+	ticker := time.NewTicker(e.retryPeriod)
+	defer ticker.Stop()
+
+	for leading := true; leading; {
+		select {
+		case <-ctx.Done():
+			leading = false
+
+		case <-ticker.C:
+			acquired, err := e.lock.TryAcquire(ctx)
+			switch {
+			case acquired:
+				renewed = time.Now()
+			case err == nil:
+				log.Warn("lost leadership to another idler", "identity", e.identity)
+				leading = false
+			case time.Since(renewed) > e.renewDeadline:
+				log.Warn("lost leadership, unable to renew", "identity", e.identity, "err", err)
+				leading = false
+			default:
+				log.Warn("unable to renew leadership", "identity", e.identity, "err", err)
+			}
+		}
+	}
+
+	if ctx.Err() == nil {
+		e.setLeading(false)
+	}
+	cancel()
+	<-done
+	e.setLeading(false)
+
+	if ctx.Err() != nil {
+		releaseCtx, releaseCancel := context.WithTimeout(context.Background(), e.retryPeriod)
+		defer releaseCancel()
+
+		if err := e.lock.Release(releaseCtx); err != nil {
+			log.Warn("unable to release leadership", "identity", e.identity, "err", err)
+		}
+	}
+}
+
+func (e *leaderElector) setLeading(leading bool) {
+	if leading {
+		atomic.StoreInt32(&e.leading, 1)
+		leaderElected.Set(1)
+		return
+	}
+	atomic.StoreInt32(&e.leading, 0)
+	leaderElected.Set(0)
+}
+
+// kubeLeaseLock is a Kubernetes Lease, held until it hasn't been renewed
+// for its duration
+type kubeLeaseLock struct {
+	client    k8s.Interface
+	namespace string
+	name      string
+	identity  string
+	duration  time.Duration
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func newKubeLeaseLock(client k8s.Interface, namespace string, name string, identity string, duration time.Duration) *kubeLeaseLock {
+	return &kubeLeaseLock{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		identity:  identity,
+		duration:  duration,
+		now:       time.Now,
+	}
+}
+
+// TryAcquire creates the Lease, renews it when held, or takes it over once
+// it has expired. A conflicting write means another idler got there first.
+func (l *kubeLeaseLock) TryAcquire(ctx context.Context) (bool, error) {
+	now := l.now()
+
+	lease, err := l.client.GetLease(ctx, l.namespace, l.name)
+	if isStatus(err, http.StatusNotFound) {
+		_, err = l.client.CreateLease(ctx, l.namespace, &k8s.Lease{
+			Metadata: k8s.ObjectMeta{Name: l.name},
+			Spec:     l.spec(k8s.LeaseSpec{}, now),
+		})
+		if isStatus(err, http.StatusConflict) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	held := len(lease.Spec.HolderIdentity) > 0 && lease.Spec.HolderIdentity != l.identity
+	if held && lease.Spec.RenewTime != nil {
+		expires := lease.Spec.RenewTime.Add(time.Duration(lease.Spec.LeaseDurationSeconds) * time.Second)
+		if now.Before(expires) {
+			return false, nil
+		}
+	}
+
+	lease.Spec = l.spec(lease.Spec, now)
+	_, err = l.client.UpdateLease(ctx, l.namespace, lease)
+	if isStatus(err, http.StatusConflict) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// spec is previous held by this idler from now
+func (l *kubeLeaseLock) spec(previous k8s.LeaseSpec, now time.Time) k8s.LeaseSpec {
+	spec := previous
+	if spec.HolderIdentity != l.identity {
+		spec.AcquireTime = k8s.NewMicroTime(now)
+		if len(previous.HolderIdentity) > 0 {
+			spec.LeaseTransitions++
+		}
+	}
+
+	spec.HolderIdentity = l.identity
+	spec.LeaseDurationSeconds = int32(l.duration / time.Second)
+	spec.RenewTime = k8s.NewMicroTime(now)
+	return spec
+}
+
+// Release clears the holder, when it is this idler
+func (l *kubeLeaseLock) Release(ctx context.Context) error {
+	lease, err := l.client.GetLease(ctx, l.namespace, l.name)
+	if err != nil {
+		return err
+	}
+	if lease.Spec.HolderIdentity != l.identity {
+		return nil
+	}
+
+	lease.Spec.HolderIdentity = ""
+	_, err = l.client.UpdateLease(ctx, l.namespace, lease)
+	return err
+}
+
+func isStatus(err error, code int) bool {
+	statusErr, ok := err.(*k8s.StatusError)
+	return ok && statusErr.Code == code
+}
+
+// fileLock is an exclusive flock on a file, for idlers sharing a host such
+// as when testing locally. The lock is dropped if the process exits.
+type fileLock struct {
+	path string
+	file *os.File
+}
+
+func newFileLock(path string) *fileLock {
+	return &fileLock{path: path}
+}
+
+// TryAcquire takes the lock without blocking, a lock already held by this
+// idler is kept
+func (l *fileLock) TryAcquire(ctx context.Context) (bool, error) {
+	if l.file != nil {
+		return true, nil
+	}
+
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return false, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to lock %s: %s", l.path, err)
+	}
+
+	l.file = file
+	return true, nil
+}
+
+// Release unlocks the file
+func (l *fileLock) Release(ctx context.Context) error {
+	if l.file == nil {
+		return nil
+	}
+
+	err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	l.file.Close()
+	l.file = nil
+	return err
+}
+
+// electionIdentity names this idler in the lock, the pod's name when set
+func electionIdentity() string {
+	if name := os.Getenv("POD_NAME"); len(name) > 0 {
+		return name
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		return hostname
+	}
+	return fmt.Sprintf("faas-idler-%d", os.Getpid())
+}
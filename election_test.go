@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/openfaas-incubator/faas-idler/k8s"
+)
+
+func Test_KubeLeaseLock(t *testing.T) {
+	client := k8s.NewFake()
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	a := newKubeLeaseLock(client, "openfaas", "faas-idler", "idler-a", 15*time.Second)
+	b := newKubeLeaseLock(client, "openfaas", "faas-idler", "idler-b", 15*time.Second)
+	a.now, b.now = clock, clock
+
+	steps := []struct {
+		name string
+		lock *kubeLeaseLock
+		want bool
+	}{
+		{name: "a creates the lease", lock: a, want: true},
+		{name: "b waits while it is held", lock: b, want: false},
+		{name: "a renews", lock: a, want: true},
+	}
+
+	for _, step := range steps {
+		acquired, err := step.lock.TryAcquire(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		if acquired != step.want {
+			t.Fatalf("%s: want acquired: %v, got: %v", step.name, step.want, acquired)
+		}
+	}
+
+	now = now.Add(16 * time.Second)
+	if acquired, _ := b.TryAcquire(context.Background()); !acquired {
+		t.Fatalf("want b to take over the expired lease")
+	}
+	if acquired, _ := a.TryAcquire(context.Background()); acquired {
+		t.Fatalf("want a to have lost the lease")
+	}
+
+	lease, _ := client.GetLease(context.Background(), "openfaas", "faas-idler")
+	if lease.Spec.HolderIdentity != "idler-b" || lease.Spec.LeaseTransitions != 1 {
+		t.Errorf("want held by idler-b after 1 transition, got: %+v", lease.Spec)
+	}
+
+	if err := b.Release(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if acquired, _ := a.TryAcquire(context.Background()); !acquired {
+		t.Errorf("want a to take the released lease straight away")
+	}
+}
+
+func Test_FileLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "faas-idler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "faas-idler.lock")
+	a, b := newFileLock(path), newFileLock(path)
+
+	if acquired, err := a.TryAcquire(context.Background()); !acquired || err != nil {
+		t.Fatalf("want a to lock, got: %v %v", acquired, err)
+	}
+	if acquired, err := b.TryAcquire(context.Background()); acquired || err != nil {
+		t.Fatalf("want b to wait, got: %v %v", acquired, err)
+	}
+
+	a.Release(context.Background())
+	if acquired, err := b.TryAcquire(context.Background()); !acquired || err != nil {
+		t.Errorf("want b to lock once released, got: %v %v", acquired, err)
+	}
+	b.Release(context.Background())
+}
+
+func Test_LeaderElectorHandsOver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "faas-idler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "faas-idler.lock")
+	a := newLeaderElector(newFileLock(path), "idler-a", 30*time.Millisecond)
+	b := newLeaderElector(newFileLock(path), "idler-b", 30*time.Millisecond)
+
+	leading := make(chan string, 2)
+	lead := func(name string) func(ctx context.Context) {
+		return func(ctx context.Context) {
+			leading <- name
+			<-ctx.Done()
+		}
+	}
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	doneA := make(chan struct{})
+	go func() {
+		a.Run(ctxA, lead("idler-a"))
+		close(doneA)
+	}()
+
+	if got := <-leading; got != "idler-a" {
+		t.Fatalf("want idler-a to lead, got: %s", got)
+	}
+
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	go b.Run(ctxB, lead("idler-b"))
+
+	time.Sleep(50 * time.Millisecond)
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatalf("want only idler-a leading, got a: %v, b: %v", a.IsLeader(), b.IsLeader())
+	}
+
+	cancelA()
+	<-doneA
+
+	select {
+	case got := <-leading:
+		if got != "idler-b" {
+			t.Errorf("want idler-b to take over, got: %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("want idler-b to take over once idler-a stopped")
+	}
+	if a.IsLeader() {
+		t.Errorf("want idler-a no longer leading")
+	}
+}
+
+func Test_NilLeaderElectorLeads(t *testing.T) {
+	var elector *leaderElector
+	if !elector.IsLeader() {
+		t.Errorf("want a nil elector to lead")
+	}
+
+	ran := false
+	elector.Run(context.Background(), func(ctx context.Context) { ran = true })
+	if !ran {
+		t.Errorf("want a nil elector to run straight away")
+	}
+}
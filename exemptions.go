@@ -0,0 +1,132 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// exemptUntilAnnotation stops a function being scaled to zero until the
+// RFC3339 time it is set to
+const exemptUntilAnnotation = "com.openfaas.scale.zero.exempt-until"
+
+const (
+	exemptionSourceConfig = "config"
+	exemptionSourceAdmin  = "admin"
+)
+
+// Exemption stops functions whose name matches Pattern from being scaled to
+// zero until it expires. Patterns use path.Match syntax, i.e. "payments-*".
+type Exemption struct {
+	Pattern string    `json:"pattern"`
+	Until   time.Time `json:"until"`
+	Source  string    `json:"source"`
+}
+
+// exemptionStore holds exemptions by pattern until they expire
+type exemptionStore struct {
+	lock       sync.RWMutex
+	exemptions map[string]Exemption
+}
+
+func newExemptionStore() *exemptionStore {
+	return &exemptionStore{
+		exemptions: make(map[string]Exemption),
+	}
+}
+
+// Add adds an exemption, replacing any other with the same pattern
+func (e *exemptionStore) Add(exemption Exemption) error {
+	if _, err := path.Match(exemption.Pattern, ""); err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.exemptions[exemption.Pattern] = exemption
+	return nil
+}
+
+// Remove removes the exemption for a pattern, returning false if there was
+// none
+func (e *exemptionStore) Remove(pattern string) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	_, ok := e.exemptions[pattern]
+	delete(e.exemptions, pattern)
+	return ok
+}
+
+// Match finds the unexpired exemption which lasts longest for a function
+func (e *exemptionStore) Match(name string, now time.Time) (Exemption, bool) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	var found Exemption
+	var ok bool
+	for _, exemption := range e.exemptions {
+		if !now.Before(exemption.Until) {
+			continue
+		}
+
+		if matched, _ := path.Match(exemption.Pattern, name); matched && exemption.Until.After(found.Until) {
+			found, ok = exemption, true
+		}
+	}
+	return found, ok
+}
+
+// List returns the unexpired exemptions sorted by pattern
+func (e *exemptionStore) List(now time.Time) []Exemption {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	list := make([]Exemption, 0, len(e.exemptions))
+	for _, exemption := range e.exemptions {
+		if now.Before(exemption.Until) {
+			list = append(list, exemption)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Pattern < list[j].Pattern
+	})
+	return list
+}
+
+// Expire removes and returns the exemptions which have expired
+func (e *exemptionStore) Expire(now time.Time) []Exemption {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	expired := []Exemption{}
+	for pattern, exemption := range e.exemptions {
+		if !now.Before(exemption.Until) {
+			expired = append(expired, exemption)
+			delete(e.exemptions, pattern)
+		}
+	}
+	return expired
+}
+
+// annotationExemption reads the exempt-until annotation of a function, an
+// unset or expired annotation is not an exemption
+func annotationExemption(annotations *map[string]string, now time.Time) (time.Time, bool, error) {
+	if annotations == nil {
+		return time.Time{}, false, nil
+	}
+
+	val, ok := (*annotations)[exemptUntilAnnotation]
+	if !ok || len(val) == 0 {
+		return time.Time{}, false, nil
+	}
+
+	until, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return until, now.Before(until), nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_ExemptionStoreMatch(t *testing.T) {
+	now := time.Now()
+	store := newExemptionStore()
+	store.Add(Exemption{Pattern: "payments-*", Until: now.Add(time.Hour), Source: exemptionSourceConfig})
+	store.Add(Exemption{Pattern: "payments-api", Until: now.Add(2 * time.Hour), Source: exemptionSourceAdmin})
+	store.Add(Exemption{Pattern: "figlet", Until: now.Add(-time.Minute), Source: exemptionSourceAdmin})
+
+	cases := []struct {
+		name      string
+		function  string
+		wantOk    bool
+		wantUntil time.Time
+	}{
+		{name: "pattern match", function: "payments-worker", wantOk: true, wantUntil: now.Add(time.Hour)},
+		{name: "longest exemption wins", function: "payments-api", wantOk: true, wantUntil: now.Add(2 * time.Hour)},
+		{name: "expired", function: "figlet"},
+		{name: "no match", function: "nodeinfo"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			exemption, ok := store.Match(c.function, now)
+			if ok != c.wantOk {
+				t.Fatalf("want match: %t, got: %t", c.wantOk, ok)
+			}
+			if ok && !exemption.Until.Equal(c.wantUntil) {
+				t.Errorf("want until: %s, got: %s", c.wantUntil, exemption.Until)
+			}
+		})
+	}
+}
+
+func Test_ExemptionStoreExpire(t *testing.T) {
+	now := time.Now()
+	store := newExemptionStore()
+	store.Add(Exemption{Pattern: "payments-*", Until: now.Add(time.Hour)})
+	store.Add(Exemption{Pattern: "figlet", Until: now.Add(-time.Minute)})
+
+	expired := store.Expire(now)
+	if len(expired) != 1 || expired[0].Pattern != "figlet" {
+		t.Errorf("want figlet expired, got: %v", expired)
+	}
+
+	list := store.List(now)
+	if len(list) != 1 || list[0].Pattern != "payments-*" {
+		t.Errorf("want payments-* left, got: %v", list)
+	}
+}
+
+func Test_ExemptionStoreRejectsBadPattern(t *testing.T) {
+	store := newExemptionStore()
+	if err := store.Add(Exemption{Pattern: "payments-[", Until: time.Now().Add(time.Hour)}); err == nil {
+		t.Errorf("want error for bad pattern")
+	}
+}
+
+func Test_AnnotationExemption(t *testing.T) {
+	now := time.Date(2020, 3, 16, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name        string
+		annotations *map[string]string
+		wantOk      bool
+		wantErr     bool
+	}{
+		{name: "no annotations"},
+		{name: "future", annotations: &map[string]string{exemptUntilAnnotation: "2020-03-16T18:00:00Z"}, wantOk: true},
+		{name: "past", annotations: &map[string]string{exemptUntilAnnotation: "2020-03-16T09:00:00Z"}},
+		{name: "invalid", annotations: &map[string]string{exemptUntilAnnotation: "tonight"}, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, ok, err := annotationExemption(c.annotations, now)
+			if ok != c.wantOk {
+				t.Errorf("want exempt: %t, got: %t", c.wantOk, ok)
+			}
+			if (err != nil) != c.wantErr {
+				t.Errorf("want error: %t, got: %v", c.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "faas_idler"
+
+var (
+	reconcileCyclesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_cycles_total",
+		Help:      "Reconcile cycles completed",
+	})
+
+	reconcileDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Time taken by each reconcile cycle, which includes waiting for the inactivity duration",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 14),
+	})
+
+	functionsEvaluatedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "functions_evaluated_total",
+		Help:      "Functions evaluated by the action taken and its reason",
+	}, []string{"action", "reason"})
+
+	scaleErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "scale_errors_total",
+		Help:      "Failed scale requests by the status code from the gateway, or \"error\" when no response was received",
+	}, []string{"code"})
+
+	metricsSourceErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "metrics_source_errors_total",
+		Help:      "Failed reads of invocation metrics",
+	})
+
+	webhookEventsDroppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "webhook_events_dropped_total",
+		Help:      "Webhook events dropped because the queue was full",
+	})
+
+	webhookDeliveryErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "webhook_delivery_errors_total",
+		Help:      "Webhook events which could not be delivered after retrying",
+	})
+
+	gatewayRetriesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "gateway_retries_total",
+		Help:      "Requests to the gateway retried after a connection error or server error",
+	})
+
+	cyclesAbortedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cycles_aborted_total",
+		Help:      "Reconcile cycles aborted before evaluating functions, by reason",
+	}, []string{"reason"})
+
+	metricsAgeSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "metrics_age_seconds",
+		Help:      "Age of the invocation counters a function was last found idle on, or of the gateway's last scrape by Prometheus when older",
+	})
+
+	leaderElected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "leader",
+		Help:      "1 while this idler is the leader and reconciles, otherwise 0",
+	})
+
+	shardFunctions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "shard_functions",
+		Help:      "Functions in this idler's shard as of the last cycle",
+	})
+
+	evaluationQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "evaluation_queue_depth",
+		Help:      "Functions waiting for a worker in the current reconcile cycle",
+	})
+
+	scaleQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "scale_queue_depth",
+		Help:      "Scale requests waiting for a slot or the scale rate limit",
+	})
+
+	wakeFlapsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "wake_flaps_total",
+		Help:      "Functions woken within the flap window of being scaled to zero, whose inactivity duration was extended",
+	})
+
+	circuitOpen = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "circuit_open",
+		Help:      "1 while calls to the gateway are paused by the circuit breaker, otherwise 0",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		reconcileCyclesTotal,
+		reconcileDuration,
+		functionsEvaluatedTotal,
+		scaleErrorsTotal,
+		metricsSourceErrorsTotal,
+		webhookEventsDroppedTotal,
+		webhookDeliveryErrorsTotal,
+		gatewayRetriesTotal,
+		circuitOpen,
+		cyclesAbortedTotal,
+		metricsAgeSeconds,
+		leaderElected,
+		shardFunctions,
+		evaluationQueueDepth,
+		scaleQueueDepth,
+		wakeFlapsTotal,
+		&stateCollector{state: state},
+	)
+}
+
+// stateCollector exports the functions tracked in idlerState at the time
+// of each scrape.
+type stateCollector struct {
+	state *idlerState
+}
+
+var (
+	trackedFunctionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "tracked_functions"),
+		"Functions whose invocation counter is being tracked",
+		nil, nil)
+
+	lastActivityDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "seconds_since_last_activity"),
+		"Seconds since the invocation counter of a function last moved",
+		[]string{"function_name"}, nil)
+
+	inactivityDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "inactivity_duration_seconds"),
+		"Inactivity duration applied to a function as of its last evaluation, extended while it flaps",
+		[]string{"function_name"}, nil)
+)
+
+// Describe is to describe the metrics for Prometheus
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- trackedFunctionsDesc
+	ch <- lastActivityDesc
+	ch <- inactivityDurationDesc
+}
+
+// Collect is called by the Prometheus registry when collecting metrics
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	functions := c.state.functions()
+
+	ch <- prometheus.MustNewConstMetric(trackedFunctionsDesc, prometheus.GaugeValue, float64(len(functions)))
+
+	now := time.Now()
+	for _, function := range functions {
+		if function.LastActivity != nil {
+			ch <- prometheus.MustNewConstMetric(lastActivityDesc, prometheus.GaugeValue, now.Sub(*function.LastActivity).Seconds(), function.Name)
+		}
+		if function.Policy != nil {
+			ch <- prometheus.MustNewConstMetric(inactivityDurationDesc, prometheus.GaugeValue, time.Duration(function.Policy.InactivityDuration).Seconds(), function.Name)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"time"
+
+	"github.com/openfaas-incubator/faas-idler/logger"
+)
+
+// Flapping is how far the inactivity duration of a function has been
+// extended after it was woken soon after being scaled to zero, it is not
+// idled again until QuietFor reaches InactivityDuration
+type Flapping struct {
+	Backoff            int       `json:"backoff"`
+	InactivityDuration Duration  `json:"inactivityDuration"`
+	QuietFor           Duration  `json:"quietFor"`
+	LastFlap           time.Time `json:"lastFlap"`
+}
+
+// tooSoon reports whether the function hasn't yet been quiet for its
+// extended inactivity duration
+func (f *Flapping) tooSoon() bool {
+	return f != nil && f.QuietFor < f.InactivityDuration
+}
+
+// decayBackoff undoes one doubling of the inactivity duration for every
+// decay since the last flap
+func decayBackoff(backoff int, lastFlap time.Time, now time.Time, decay time.Duration) int {
+	if backoff == 0 || decay <= 0 {
+		return backoff
+	}
+
+	backoff -= int(now.Sub(lastFlap) / decay)
+	if backoff < 0 {
+		return 0
+	}
+	return backoff
+}
+
+// flapping returns how far the inactivity duration of a function is
+// extended, or nil when it isn't
+func (c *Controller) flapping(name string, now time.Time) *Flapping {
+	if c.config.FlapWindow <= 0 {
+		return nil
+	}
+
+	backoff, lastFlap := c.state.backoff(name, now, c.config.FlapDecay)
+	if backoff == 0 {
+		return nil
+	}
+
+	return &Flapping{
+		Backoff:            backoff,
+		InactivityDuration: Duration(c.config.InactivityDuration << uint(backoff)),
+		QuietFor:           Duration(now.Sub(c.state.quietSince(name))),
+		LastFlap:           lastFlap,
+	}
+}
+
+// detectFlap extends the inactivity duration of a function woken within
+// FlapWindow of the idler scaling it to zero
+func (c *Controller) detectFlap(name string, replicas uint64, now time.Time, log *logger.Logger) {
+	if c.config.FlapWindow <= 0 {
+		return
+	}
+
+	idled, ok := c.state.wake(name, replicas, now)
+	if !ok {
+		return
+	}
+	if idled > c.config.FlapWindow {
+		log.Debug("function woken", "idled_for", idled)
+		return
+	}
+
+	backoff := c.state.addFlap(name, now, c.config.FlapMaxBackoff, c.config.FlapDecay)
+	wakeFlapsTotal.Inc()
+	log.Info("function woken soon after being scaled to zero, extending its inactivity duration",
+		"idled_for", idled, "backoff", backoff, "inactivity_duration", c.config.InactivityDuration<<uint(backoff))
+}
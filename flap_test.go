@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_decayBackoff(t *testing.T) {
+	lastFlap := time.Now()
+
+	cases := []struct {
+		name    string
+		backoff int
+		since   time.Duration
+		want    int
+	}{
+		{name: "no backoff", since: 5 * time.Hour, want: 0},
+		{name: "within decay", backoff: 3, since: 59 * time.Minute, want: 3},
+		{name: "one decay", backoff: 3, since: 90 * time.Minute, want: 2},
+		{name: "decayed away", backoff: 3, since: 5 * time.Hour, want: 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := decayBackoff(c.backoff, lastFlap, lastFlap.Add(c.since), time.Hour); got != c.want {
+				t.Errorf("want: %d, got: %d", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_addFlapCapsBackoff(t *testing.T) {
+	s := newIdlerState()
+	s.setTouch("figlet", 1)
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		s.addFlap("figlet", now, 3, time.Hour)
+	}
+
+	if backoff, _ := s.backoff("figlet", now, time.Hour); backoff != 3 {
+		t.Errorf("want backoff: 3, got: %d", backoff)
+	}
+	if backoff, _ := s.backoff("figlet", now.Add(2*time.Hour), time.Hour); backoff != 1 {
+		t.Errorf("want backoff after 2 decays: 1, got: %d", backoff)
+	}
+}
+
+func Test_ControllerDetectsFlapping(t *testing.T) {
+	cases := []struct {
+		name       string
+		idledFor   time.Duration
+		wantReason Reason
+		wantWindow time.Duration
+	}{
+		{name: "woken straight away", wantReason: ReasonFlapping, wantWindow: 40 * time.Millisecond},
+		{name: "woken after the flap window", idledFor: 2 * time.Hour, wantReason: ReasonIdle, wantWindow: 20 * time.Millisecond},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			scaler := newMemoryScaler(labelled("figlet", 1))
+			controller := newTestController(scaler, &fakeInvocations{counts: map[string]float64{}})
+			controller.config.InactivityDuration = 20 * time.Millisecond
+			controller.config.FlapWindow = time.Hour
+			controller.config.FlapMaxBackoff = 4
+			controller.config.FlapDecay = time.Hour
+
+			decisions := controller.reconcile(context.Background(), reconcileOptions{prime: true})
+			if decisions[0].Reason != ReasonIdle {
+				t.Fatalf("want: %s, got: %s", ReasonIdle, decisions[0].Reason)
+			}
+
+			controller.state.setIdled("figlet", time.Now().Add(-c.idledFor))
+			scaler.Scale(context.Background(), Function{Name: "figlet"}, 1)
+
+			decisions = controller.reconcile(context.Background(), reconcileOptions{})
+			if decisions[0].Reason != c.wantReason {
+				t.Errorf("want: %s, got: %s", c.wantReason, decisions[0].Reason)
+			}
+			if got := time.Duration(decisions[0].Policy.InactivityDuration); got != c.wantWindow {
+				t.Errorf("want inactivity duration: %s, got: %s", c.wantWindow, got)
+			}
+
+			function, _ := controller.state.function("figlet")
+			if (function.Flapping != nil) != (c.wantReason == ReasonFlapping) {
+				t.Errorf("want flapping in the status: %v, got: %+v", c.wantReason == ReasonFlapping, function.Flapping)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	providerTypes "github.com/openfaas/faas-provider/types"
+)
+
+// gatewayBackoff is the wait before the first retry of a read from the
+// gateway, each retry after waits twice as long
+const gatewayBackoff = 500 * time.Millisecond
+
+// gatewayStatusError is returned when the gateway answers with a status
+// other than 2xx
+type gatewayStatusError struct {
+	Code int
+}
+
+func (e *gatewayStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code from gateway: %d", e.Code)
+}
+
+// gatewayUnhealthy reports whether err means the gateway couldn't be
+// reached or failed, rather than refusing the request
+func gatewayUnhealthy(err error) bool {
+	if err == nil || err == errCircuitOpen {
+		return false
+	}
+
+	if statusErr, ok := err.(*gatewayStatusError); ok {
+		return statusErr.Code == http.StatusTooManyRequests || statusErr.Code >= http.StatusInternalServerError
+	}
+	return true
+}
+
+// gatewayScaler lists, reads and scales functions through the OpenFaaS
+// gateway's system API
+type gatewayScaler struct {
+	client      *http.Client
+	gatewayURL  string
+	credentials *Credentials
+
+	// retries is how many times a failed read is retried, after backoff
+	// with jitter
+	retries int
+	backoff time.Duration
+
+	// breaker pauses calls once the gateway keeps failing, if set
+	breaker *circuitBreaker
+}
+
+// ListFunctions returns every function deployed through the gateway
+func (g *gatewayScaler) ListFunctions(ctx context.Context) ([]providerTypes.FunctionStatus, error) {
+	list := []providerTypes.FunctionStatus{}
+
+	if err := g.get(ctx, "system/functions", &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// GetReplicas reads a function's replicas from the gateway
+func (g *gatewayScaler) GetReplicas(ctx context.Context, fn Function) (*providerTypes.FunctionStatus, error) {
+	item := &providerTypes.FunctionStatus{}
+
+	if err := g.get(ctx, "system/function/"+fn.Name, item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// Scale sets a function's replicas through the gateway. Scale requests are
+// not retried, the next cycle will try again.
+func (g *gatewayScaler) Scale(ctx context.Context, fn Function, replicas uint64) (scaleResponse, error) {
+	response := scaleResponse{}
+
+	if err := g.breaker.Allow(); err != nil {
+		return response, err
+	}
+
+	scaleReq := providerTypes.ScaleServiceRequest{
+		ServiceName: fn.Name,
+		Replicas:    replicas,
+	}
+
+	bodyBytes, _ := json.Marshal(scaleReq)
+	bodyReader := bytes.NewReader(bodyBytes)
+
+	req, _ := http.NewRequest(http.MethodPost, g.gatewayURL+"system/scale-function/"+fn.Name, bodyReader)
+	req = req.WithContext(ctx)
+	req.SetBasicAuth(g.credentials.Username, g.credentials.Password)
+
+	start := time.Now()
+	res, err := g.client.Do(req)
+	response.Latency = time.Since(start)
+
+	if err != nil {
+		scaleErrorsTotal.WithLabelValues("error").Inc()
+		g.breaker.Record(gatewayUnhealthy(err))
+		return response, err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	response.StatusCode = res.StatusCode
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		scaleErrorsTotal.WithLabelValues(strconv.Itoa(res.StatusCode)).Inc()
+		err = &gatewayStatusError{Code: res.StatusCode}
+		log.Warn("unable to scale function", "function", fn.Name, "status", res.StatusCode, "replicas", replicas)
+	} else {
+		log.Info("scaled function", "function", fn.Name, "status", res.StatusCode, "replicas", replicas)
+	}
+
+	g.breaker.Record(gatewayUnhealthy(err))
+	return response, err
+}
+
+// get reads path from the gateway into out, retrying with exponential
+// backoff and jitter when the gateway can't be reached or fails
+func (g *gatewayScaler) get(ctx context.Context, path string, out interface{}) error {
+	if err := g.breaker.Allow(); err != nil {
+		return err
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = g.getOnce(ctx, path, out)
+		if !gatewayUnhealthy(err) || attempt >= g.retries || ctx.Err() != nil {
+			break
+		}
+
+		gatewayRetriesTotal.Inc()
+		log.Debug("retrying gateway request", "path", path, "attempt", attempt+1, "err", err)
+
+		if !sleep(ctx, jitter(g.backoff<<uint(attempt))) {
+			break
+		}
+	}
+
+	if ctx.Err() == nil {
+		g.breaker.Record(gatewayUnhealthy(err))
+	}
+	return err
+}
+
+func (g *gatewayScaler) getOnce(ctx context.Context, path string, out interface{}) error {
+	req, _ := http.NewRequest(http.MethodGet, g.gatewayURL+path, nil)
+	req = req.WithContext(ctx)
+	req.SetBasicAuth(g.credentials.Username, g.credentials.Password)
+
+	res, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	bytesOut, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return &gatewayStatusError{Code: res.StatusCode}
+	}
+
+	return json.Unmarshal(bytesOut, out)
+}
+
+// jitter returns a random duration between half of d and d, so that
+// retries from many goroutines don't arrive at the gateway together
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// sleep waits for d, or returns false when ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
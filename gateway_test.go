@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestGateway answers each request with the next of statuses, then with
+// 200 and body
+func newTestGateway(body string, statuses ...int) (*gatewayScaler, func() int, func()) {
+	var lock sync.Mutex
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		attempt := requests
+		requests++
+		lock.Unlock()
+
+		if attempt < len(statuses) {
+			w.WriteHeader(statuses[attempt])
+			return
+		}
+		w.Write([]byte(body))
+	}))
+
+	gateway := &gatewayScaler{
+		client:      server.Client(),
+		gatewayURL:  server.URL + "/",
+		credentials: &Credentials{},
+		retries:     3,
+		backoff:     time.Millisecond,
+	}
+
+	return gateway, func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return requests
+	}, server.Close
+}
+
+func Test_GatewayGetRetries(t *testing.T) {
+	cases := []struct {
+		name         string
+		statuses     []int
+		wantErr      bool
+		wantRequests int
+	}{
+		{name: "succeeds first time", wantRequests: 1},
+		{name: "retries server errors", statuses: []int{http.StatusBadGateway, http.StatusServiceUnavailable}, wantRequests: 3},
+		{name: "retries too many requests", statuses: []int{http.StatusTooManyRequests}, wantRequests: 2},
+		{name: "gives up after retries", statuses: []int{500, 500, 500, 500, 500}, wantErr: true, wantRequests: 4},
+		{name: "does not retry not found", statuses: []int{http.StatusNotFound}, wantErr: true, wantRequests: 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			gateway, requests, done := newTestGateway(`{"name":"figlet","availableReplicas":1}`, c.statuses...)
+			defer done()
+
+			function, err := gateway.GetReplicas(context.Background(), Function{Name: "figlet"})
+			if c.wantErr && err == nil {
+				t.Errorf("want error")
+			}
+			if !c.wantErr {
+				if err != nil {
+					t.Fatalf("want no error, got: %s", err)
+				}
+				if function.AvailableReplicas != 1 {
+					t.Errorf("want 1 available replica, got: %d", function.AvailableReplicas)
+				}
+			}
+			if got := requests(); got != c.wantRequests {
+				t.Errorf("want requests: %d, got: %d", c.wantRequests, got)
+			}
+		})
+	}
+}
+
+func Test_GatewayScaleStatusErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "accepted", status: http.StatusAccepted},
+		{name: "not found", status: http.StatusNotFound, wantErr: true},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			gateway, requests, done := newTestGateway("", c.status)
+			defer done()
+
+			res, err := gateway.Scale(context.Background(), Function{Name: "figlet"}, 0)
+			if c.wantErr != (err != nil) {
+				t.Errorf("want error: %v, got: %v", c.wantErr, err)
+			}
+			if res.StatusCode != c.status {
+				t.Errorf("want status: %d, got: %d", c.status, res.StatusCode)
+			}
+			if got := requests(); got != 1 {
+				t.Errorf("want scale requests not to be retried, got: %d requests", got)
+			}
+		})
+	}
+}
+
+func Test_GatewayCircuitBreaker(t *testing.T) {
+	gateway, requests, done := newTestGateway("", 500, 500, 500, 500)
+	defer done()
+
+	gateway.retries = 0
+	gateway.breaker = newCircuitBreaker(2, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if _, err := gateway.ListFunctions(context.Background()); err == nil {
+			t.Fatalf("want error from a failing gateway")
+		}
+	}
+
+	if _, err := gateway.Scale(context.Background(), Function{Name: "figlet"}, 0); err != errCircuitOpen {
+		t.Errorf("want: %s, got: %v", errCircuitOpen, err)
+	}
+	if got := requests(); got != 2 {
+		t.Errorf("want no requests while the circuit is open, got: %d requests", got)
+	}
+}
+
+func Test_GatewayNotFoundKeepsCircuitClosed(t *testing.T) {
+	gateway, _, done := newTestGateway("", 404, 404, 404)
+	defer done()
+
+	gateway.breaker = newCircuitBreaker(2, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		gateway.GetReplicas(context.Background(), Function{Name: "missing"})
+	}
+
+	if gateway.breaker.Open() {
+		t.Errorf("want client errors not to open the circuit")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// probes backs the health endpoints used by Kubernetes
+type probes struct {
+	// ready returns an error when a dependency of the idler is unreachable
+	ready func(ctx context.Context) error
+
+	// deadline is how long a cycle may go without moving before the idler
+	// is reported as not alive
+	deadline time.Duration
+
+	// started counts as the last cycle until the first one completes
+	started time.Time
+
+	state *idlerState
+
+	// leader reports whether this idler reconciles, followers are alive
+	// without completing cycles. Always leading when nil.
+	leader func() bool
+
+	// leadingSince is when this idler last became leader, as UnixNano
+	leadingSince int64
+}
+
+// setLeading starts the deadline over when this idler becomes leader
+func (p *probes) setLeading(since time.Time) {
+	atomic.StoreInt64(&p.leadingSince, since.UnixNano())
+}
+
+// healthz is OK while the process can serve HTTP
+func (p *probes) healthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// readyz is OK when the gateway and the metrics source can be reached
+func (p *probes) readyz(w http.ResponseWriter, r *http.Request) {
+	if err := p.ready(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// livez fails when no reconcile cycle has completed or moved within the
+// deadline, a cycle over many functions moves as each is read
+func (p *probes) livez(w http.ResponseWriter, r *http.Request) {
+	if p.leader != nil && !p.leader() {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK, following"))
+		return
+	}
+
+	last := p.state.progressed()
+	if last.IsZero() {
+		last = p.started
+	}
+	if since := atomic.LoadInt64(&p.leadingSince); since > 0 && last.UnixNano() < since {
+		last = time.Unix(0, since)
+	}
+
+	if since := time.Since(last); since > p.deadline {
+		msg := fmt.Sprintf("no reconcile progress for %s, deadline: %s", since.Round(time.Second), p.deadline)
+		http.Error(w, msg, http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// checkReady returns an error when the gateway or the metrics source can't
+// be reached
+func checkReady(ctx context.Context, client *http.Client, gatewayURL string, metricsURL string, credentials *Credentials) error {
+	if _, err := getVersion(ctx, client, gatewayURL, credentials); err != nil {
+		return fmt.Errorf("gateway unreachable: %s", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, metricsURL, nil)
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("metrics source unreachable: %s", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code from metrics source: %d", res.StatusCode)
+	}
+	return nil
+}
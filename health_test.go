@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openfaas-incubator/faas-idler/types"
+)
+
+func Test_Healthz(t *testing.T) {
+	p := &probes{state: newIdlerState()}
+
+	rec := httptest.NewRecorder()
+	p.healthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("want status: %d, got: %d", http.StatusOK, rec.Code)
+	}
+}
+
+func Test_Readyz(t *testing.T) {
+	cases := []struct {
+		name  string
+		ready func(ctx context.Context) error
+		want  int
+	}{
+		{
+			name:  "dependencies reachable",
+			ready: func(ctx context.Context) error { return nil },
+			want:  http.StatusOK,
+		},
+		{
+			name:  "gateway unreachable",
+			ready: func(ctx context.Context) error { return errors.New("gateway unreachable") },
+			want:  http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := &probes{ready: c.ready, state: newIdlerState()}
+
+			rec := httptest.NewRecorder()
+			p.readyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if rec.Code != c.want {
+				t.Errorf("want status: %d, got: %d", c.want, rec.Code)
+			}
+		})
+	}
+}
+
+func Test_Livez(t *testing.T) {
+	cases := []struct {
+		name      string
+		started   time.Time
+		lastCycle time.Time
+		progress  time.Time
+		following bool
+		leading   time.Time
+		want      int
+	}{
+		{
+			name:    "starting up within deadline",
+			started: time.Now(),
+			want:    http.StatusOK,
+		},
+		{
+			name:    "no cycle since start",
+			started: time.Now().Add(-time.Hour),
+			want:    http.StatusServiceUnavailable,
+		},
+		{
+			name:      "recent cycle",
+			started:   time.Now().Add(-time.Hour),
+			lastCycle: time.Now().Add(-time.Minute),
+			want:      http.StatusOK,
+		},
+		{
+			name:      "stalled cycle",
+			started:   time.Now().Add(-time.Hour),
+			lastCycle: time.Now().Add(-30 * time.Minute),
+			want:      http.StatusServiceUnavailable,
+		},
+		{
+			name:      "long cycle still moving",
+			started:   time.Now().Add(-time.Hour),
+			lastCycle: time.Now().Add(-30 * time.Minute),
+			progress:  time.Now().Add(-time.Minute),
+			want:      http.StatusOK,
+		},
+		{
+			name:      "follower",
+			started:   time.Now().Add(-time.Hour),
+			following: true,
+			want:      http.StatusOK,
+		},
+		{
+			name:    "new leader within deadline",
+			started: time.Now().Add(-time.Hour),
+			leading: time.Now().Add(-time.Minute),
+			want:    http.StatusOK,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := newIdlerState()
+			if !c.lastCycle.IsZero() {
+				s.setLastCycle(c.lastCycle)
+			}
+			if !c.progress.IsZero() {
+				s.setProgress(c.progress)
+			}
+			p := &probes{deadline: 10 * time.Minute, started: c.started, state: s}
+			p.leader = func() bool { return !c.following }
+			if !c.leading.IsZero() {
+				p.setLeading(c.leading)
+			}
+
+			rec := httptest.NewRecorder()
+			p.livez(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+
+			if rec.Code != c.want {
+				t.Errorf("want status: %d, got: %d, body: %s", c.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func Test_CheckReady(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/system/info" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"version":{"release":"0.18.10","sha":"80b6976"}}`))
+	}))
+	defer gateway.Close()
+
+	metricsUp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("gateway_function_invocation_total{code=\"200\",function_name=\"figlet\"} 1\n"))
+	}))
+	defer metricsUp.Close()
+
+	metricsDown := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer metricsDown.Close()
+
+	client := &http.Client{Timeout: time.Second}
+	credentials := &Credentials{}
+
+	if err := checkReady(context.Background(), client, gateway.URL+"/", metricsUp.URL, credentials); err != nil {
+		t.Errorf("want ready, got: %s", err)
+	}
+
+	if err := checkReady(context.Background(), client, gateway.URL+"/", metricsDown.URL, credentials); err == nil {
+		t.Errorf("want error when metrics source fails")
+	}
+
+	if err := checkReady(context.Background(), client, "http://127.0.0.1:1/", metricsUp.URL, credentials); err == nil {
+		t.Errorf("want error when gateway is unreachable")
+	}
+}
+
+func Test_livenessDeadline(t *testing.T) {
+	config := types.Config{
+		InactivityDuration: 5 * time.Minute,
+		ReconcileInterval:  30 * time.Second,
+		LivenessThreshold:  3,
+		GatewayTimeout:     10 * time.Second,
+		GatewayRetries:     3,
+		ScaleRateLimit:     20,
+	}
+
+	// 5m, then 7 requests of 4 attempts with 3.5s of backoff, 3s for the
+	// rate limit and 3 intervals
+	want := 5*time.Minute + 7*(40*time.Second+3500*time.Millisecond) + 3*time.Second + 90*time.Second
+	if got := livenessDeadline(config); got != want {
+		t.Errorf("want: %s, got: %s", want, got)
+	}
+}
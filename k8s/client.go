@@ -0,0 +1,231 @@
+// Package k8s is a minimal client for the parts of the Kubernetes API the
+// idler uses, configured from the pod's service account.
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const serviceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Interface is the Kubernetes API used by the idler, Client talks to an
+// API server and Fake records calls for tests
+type Interface interface {
+	CreateEvent(ctx context.Context, namespace string, event *Event) error
+	GetScale(ctx context.Context, namespace string, deployment string) (*Scale, error)
+	UpdateScale(ctx context.Context, namespace string, deployment string, replicas int32) (*Scale, error)
+	GetLease(ctx context.Context, namespace string, name string) (*Lease, error)
+	CreateLease(ctx context.Context, namespace string, lease *Lease) (*Lease, error)
+	UpdateLease(ctx context.Context, namespace string, lease *Lease) (*Lease, error)
+}
+
+// Config is how to reach and authenticate to the API server
+type Config struct {
+	Host   string
+	Token  string
+	CAFile string
+
+	// TokenFile is read for the token on every request when set, as the
+	// projected service account token is rotated while the pod runs
+	TokenFile string
+}
+
+// InClusterConfig reads the API server's address from the environment and
+// the credentials of the pod's service account
+func InClusterConfig() (*Config, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if len(host) == 0 || len(port) == 0 {
+		return nil, fmt.Errorf("not running in a cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be set")
+	}
+
+	tokenFile := path.Join(serviceAccountPath, "token")
+	token, err := readToken(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Config{
+		Host:      "https://" + net.JoinHostPort(host, port),
+		Token:     token,
+		TokenFile: tokenFile,
+		CAFile:    path.Join(serviceAccountPath, "ca.crt"),
+	}, nil
+}
+
+func readToken(tokenFile string) (string, error) {
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to read service account token: %s", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// Client calls the API server over HTTPS with a bearer token
+type Client struct {
+	host      string
+	token     string
+	tokenFile string
+	client    *http.Client
+}
+
+// NewForConfig returns a Client which trusts the CA in config
+func NewForConfig(config *Config) (*Client, error) {
+	tlsConfig := &tls.Config{}
+	if len(config.CAFile) > 0 {
+		ca, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA: %s", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in CA: %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &Client{
+		host:      strings.TrimRight(config.Host, "/"),
+		token:     config.Token,
+		tokenFile: config.TokenFile,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// CreateEvent creates an Event in namespace
+func (c *Client) CreateEvent(ctx context.Context, namespace string, event *Event) error {
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("/api/v1/namespaces/%s/events", namespace), "application/json", event, nil)
+}
+
+// GetScale reads the scale subresource of a Deployment
+func (c *Client) GetScale(ctx context.Context, namespace string, deployment string) (*Scale, error) {
+	scale := &Scale{}
+	err := c.do(ctx, http.MethodGet, scalePath(namespace, deployment), "", nil, scale)
+	return scale, err
+}
+
+// UpdateScale patches the desired replicas of a Deployment through its
+// scale subresource
+func (c *Client) UpdateScale(ctx context.Context, namespace string, deployment string, replicas int32) (*Scale, error) {
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas": replicas,
+		},
+	}
+
+	scale := &Scale{}
+	err := c.do(ctx, http.MethodPatch, scalePath(namespace, deployment), "application/merge-patch+json", patch, scale)
+	return scale, err
+}
+
+// GetLease reads a Lease
+func (c *Client) GetLease(ctx context.Context, namespace string, name string) (*Lease, error) {
+	lease := &Lease{}
+	err := c.do(ctx, http.MethodGet, leasePath(namespace, name), "", nil, lease)
+	return lease, err
+}
+
+// CreateLease creates a Lease, which fails with a conflict when it exists
+func (c *Client) CreateLease(ctx context.Context, namespace string, lease *Lease) (*Lease, error) {
+	created := &Lease{}
+	err := c.do(ctx, http.MethodPost, fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", namespace), "application/json", withLeaseKind(lease), created)
+	return created, err
+}
+
+// UpdateLease replaces a Lease, which fails with a conflict when it was
+// changed since its ResourceVersion was read
+func (c *Client) UpdateLease(ctx context.Context, namespace string, lease *Lease) (*Lease, error) {
+	updated := &Lease{}
+	err := c.do(ctx, http.MethodPut, leasePath(namespace, lease.Metadata.Name), "application/json", withLeaseKind(lease), updated)
+	return updated, err
+}
+
+func withLeaseKind(lease *Lease) *Lease {
+	copied := *lease
+	copied.APIVersion = "coordination.k8s.io/v1"
+	copied.Kind = "Lease"
+	return &copied
+}
+
+func leasePath(namespace string, name string) string {
+	return fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases/%s", namespace, name)
+}
+
+func scalePath(namespace string, deployment string) string {
+	return fmt.Sprintf("/apis/apps/v1/namespaces/%s/deployments/%s/scale", namespace, deployment)
+}
+
+// do sends body as JSON and decodes the response into out, when given
+func (c *Client) do(ctx context.Context, method string, uri string, contentType string, body interface{}, out interface{}) error {
+	var reader *bytes.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(bodyBytes)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, c.host+uri, reader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	token := c.token
+	if len(c.tokenFile) > 0 {
+		if token, err = readToken(c.tokenFile); err != nil {
+			return err
+		}
+	}
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	resBytes, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return &StatusError{Code: res.StatusCode, Message: string(resBytes)}
+	}
+
+	if out != nil {
+		return json.Unmarshal(resBytes, out)
+	}
+	return nil
+}
+
+// StatusError is returned when the API server doesn't accept a request
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code from Kubernetes API: %d, %s", e.Code, strings.TrimSpace(e.Message))
+}
@@ -0,0 +1,198 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_CreateEvent(t *testing.T) {
+	var gotPath, gotAuth string
+	got := Event{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.Method + " " + r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("event is not JSON: %s", err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	client, err := NewForConfig(&Config{Host: server.URL, Token: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.CreateEvent(context.Background(), "openfaas-fn", &Event{
+		InvolvedObject: ObjectReference{Kind: "Deployment", Name: "figlet"},
+		Reason:         "ScaledToZero",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gotPath != "POST /api/v1/namespaces/openfaas-fn/events" {
+		t.Errorf("want POST to the namespace's events, got: %s", gotPath)
+	}
+	if gotAuth != "Bearer token" {
+		t.Errorf("want bearer token, got: %q", gotAuth)
+	}
+	if got.Reason != "ScaledToZero" || got.InvolvedObject.Name != "figlet" {
+		t.Errorf("want ScaledToZero for figlet, got: %+v", got)
+	}
+}
+
+func Test_ClientRereadsRotatedToken(t *testing.T) {
+	var gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "k8s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewForConfig(&Config{Host: server.URL, Token: "first", TokenFile: tokenFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{"first", "rotated"} {
+		if err := ioutil.WriteFile(tokenFile, []byte(token+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.CreateEvent(context.Background(), "openfaas-fn", &Event{}); err != nil {
+			t.Fatal(err)
+		}
+		if gotAuth != "Bearer "+token {
+			t.Errorf("want token: %s, got: %q", token, gotAuth)
+		}
+	}
+}
+
+func Test_CreateEventRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	client, err := NewForConfig(&Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.CreateEvent(context.Background(), "openfaas-fn", &Event{})
+	statusErr, ok := err.(*StatusError)
+	if !ok || statusErr.Code != http.StatusForbidden {
+		t.Errorf("want StatusError with code: %d, got: %v", http.StatusForbidden, err)
+	}
+}
+
+func Test_UpdateScale(t *testing.T) {
+	var gotMethod, gotContentType, gotBody string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/apps/v1/namespaces/openfaas-fn/deployments/figlet/scale" {
+			http.NotFound(w, r)
+			return
+		}
+
+		gotMethod = r.Method
+		gotContentType = r.Header.Get("Content-Type")
+		body, _ := ioutil.ReadAll(r.Body)
+		gotBody = string(body)
+
+		w.Write([]byte(`{"metadata":{"name":"figlet","namespace":"openfaas-fn"},"spec":{"replicas":0},"status":{"replicas":2}}`))
+	}))
+	defer server.Close()
+
+	client, err := NewForConfig(&Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scale, err := client.UpdateScale(context.Background(), "openfaas-fn", "figlet", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gotMethod != http.MethodPatch || gotContentType != "application/merge-patch+json" {
+		t.Errorf("want merge patch, got: %s %s", gotMethod, gotContentType)
+	}
+	if gotBody != `{"spec":{"replicas":0}}` {
+		t.Errorf("want replicas patched to 0, got: %s", gotBody)
+	}
+	if scale.Spec.Replicas != 0 || scale.Status.Replicas != 2 {
+		t.Errorf("want spec 0 and status 2 replicas, got: %+v", scale)
+	}
+
+	if _, err := client.GetScale(context.Background(), "openfaas-fn", "missing"); err == nil {
+		t.Errorf("want error for missing deployment")
+	}
+}
+
+func Test_UpdateLease(t *testing.T) {
+	var gotMethod string
+	got := map[string]interface{}{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/coordination.k8s.io/v1/namespaces/openfaas/leases/faas-idler" {
+			http.NotFound(w, r)
+			return
+		}
+
+		gotMethod = r.Method
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &got)
+
+		w.Write([]byte(`{"metadata":{"name":"faas-idler","namespace":"openfaas","resourceVersion":"8"},"spec":{"holderIdentity":"faas-idler-0","renewTime":"2020-03-16T18:00:00.123456Z"}}`))
+	}))
+	defer server.Close()
+
+	client, err := NewForConfig(&Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renew := time.Date(2020, 3, 16, 18, 0, 0, 123456789, time.UTC)
+	lease, err := client.UpdateLease(context.Background(), "openfaas", &Lease{
+		Metadata: ObjectMeta{Name: "faas-idler", ResourceVersion: "7"},
+		Spec:     LeaseSpec{HolderIdentity: "faas-idler-0", RenewTime: NewMicroTime(renew)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gotMethod != http.MethodPut || got["kind"] != "Lease" {
+		t.Errorf("want a Lease PUT, got: %s %v", gotMethod, got["kind"])
+	}
+	if metadata, _ := got["metadata"].(map[string]interface{}); metadata["resourceVersion"] != "7" {
+		t.Errorf("want resourceVersion 7 sent, got: %v", got["metadata"])
+	}
+	if spec, _ := got["spec"].(map[string]interface{}); spec["renewTime"] != "2020-03-16T18:00:00.123456Z" {
+		t.Errorf("want renewTime in microseconds, got: %v", spec["renewTime"])
+	}
+
+	if lease.Metadata.ResourceVersion != "8" || !lease.Spec.RenewTime.Equal(renew.Truncate(time.Microsecond)) {
+		t.Errorf("want the updated lease, got: %+v", lease)
+	}
+}
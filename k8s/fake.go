@@ -0,0 +1,145 @@
+package k8s
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Fake records the objects it is sent, in place of an API server
+type Fake struct {
+	lock   sync.Mutex
+	events []Event
+	scales map[string]*Scale
+	leases map[string]*Lease
+
+	// version is the last ResourceVersion given to a Lease
+	version int
+}
+
+// NewFake returns an empty Fake
+func NewFake() *Fake {
+	return &Fake{
+		scales: map[string]*Scale{},
+		leases: map[string]*Lease{},
+	}
+}
+
+// CreateEvent records event
+func (f *Fake) CreateEvent(ctx context.Context, namespace string, event *Event) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	created := *event
+	created.Metadata.Namespace = namespace
+	f.events = append(f.events, created)
+	return nil
+}
+
+// Events returns the events created so far
+func (f *Fake) Events() []Event {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]Event{}, f.events...)
+}
+
+// AddDeployment adds a Deployment with replicas running
+func (f *Fake) AddDeployment(namespace string, deployment string, replicas int32) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.scales[namespace+"/"+deployment] = &Scale{
+		Metadata: ObjectMeta{Name: deployment, Namespace: namespace},
+		Spec:     ScaleSpec{Replicas: replicas},
+		Status:   ScaleStatus{Replicas: replicas},
+	}
+}
+
+// GetScale returns the scale of a Deployment added with AddDeployment
+func (f *Fake) GetScale(ctx context.Context, namespace string, deployment string) (*Scale, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	scale, ok := f.scales[namespace+"/"+deployment]
+	if !ok {
+		return nil, &StatusError{Code: http.StatusNotFound, Message: "deployment not found: " + deployment}
+	}
+
+	copied := *scale
+	return &copied, nil
+}
+
+// UpdateScale sets the desired and running replicas of a Deployment
+func (f *Fake) UpdateScale(ctx context.Context, namespace string, deployment string, replicas int32) (*Scale, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	scale, ok := f.scales[namespace+"/"+deployment]
+	if !ok {
+		return nil, &StatusError{Code: http.StatusNotFound, Message: "deployment not found: " + deployment}
+	}
+
+	scale.Spec.Replicas = replicas
+	scale.Status.Replicas = replicas
+
+	copied := *scale
+	return &copied, nil
+}
+
+// GetLease returns a Lease created with CreateLease
+func (f *Fake) GetLease(ctx context.Context, namespace string, name string) (*Lease, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	lease, ok := f.leases[namespace+"/"+name]
+	if !ok {
+		return nil, &StatusError{Code: http.StatusNotFound, Message: "lease not found: " + name}
+	}
+
+	copied := *lease
+	return &copied, nil
+}
+
+// CreateLease stores a Lease, unless one exists with the same name
+func (f *Fake) CreateLease(ctx context.Context, namespace string, lease *Lease) (*Lease, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	key := namespace + "/" + lease.Metadata.Name
+	if _, ok := f.leases[key]; ok {
+		return nil, &StatusError{Code: http.StatusConflict, Message: "lease already exists: " + lease.Metadata.Name}
+	}
+
+	return f.storeLease(key, namespace, lease), nil
+}
+
+// UpdateLease replaces a Lease, unless it changed since lease was read
+func (f *Fake) UpdateLease(ctx context.Context, namespace string, lease *Lease) (*Lease, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	key := namespace + "/" + lease.Metadata.Name
+	current, ok := f.leases[key]
+	if !ok {
+		return nil, &StatusError{Code: http.StatusNotFound, Message: "lease not found: " + lease.Metadata.Name}
+	}
+	if current.Metadata.ResourceVersion != lease.Metadata.ResourceVersion {
+		return nil, &StatusError{Code: http.StatusConflict, Message: "lease was modified: " + lease.Metadata.Name}
+	}
+
+	return f.storeLease(key, namespace, lease), nil
+}
+
+func (f *Fake) storeLease(key string, namespace string, lease *Lease) *Lease {
+	f.version++
+
+	stored := *lease
+	stored.Metadata.Namespace = namespace
+	stored.Metadata.ResourceVersion = strconv.Itoa(f.version)
+	f.leases[key] = &stored
+
+	copied := stored
+	return &copied
+}
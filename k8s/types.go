@@ -0,0 +1,117 @@
+package k8s
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ObjectMeta is the metadata common to every object
+type ObjectMeta struct {
+	Name         string `json:"name,omitempty"`
+	GenerateName string `json:"generateName,omitempty"`
+	Namespace    string `json:"namespace,omitempty"`
+
+	// ResourceVersion makes an update fail with a conflict when the object
+	// was changed since it was read
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+// ObjectReference identifies the object an Event is about
+type ObjectReference struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+// EventSource is the component which reported an Event
+type EventSource struct {
+	Component string `json:"component,omitempty"`
+}
+
+const (
+	// EventTypeNormal is for information
+	EventTypeNormal = "Normal"
+	// EventTypeWarning is for something which may need attention
+	EventTypeWarning = "Warning"
+)
+
+// Event is a core/v1 Event, as shown by kubectl describe
+type Event struct {
+	Metadata       ObjectMeta      `json:"metadata"`
+	InvolvedObject ObjectReference `json:"involvedObject"`
+	Reason         string          `json:"reason"`
+	Message        string          `json:"message"`
+	Type           string          `json:"type"`
+	Source         EventSource     `json:"source"`
+	FirstTimestamp time.Time       `json:"firstTimestamp"`
+	LastTimestamp  time.Time       `json:"lastTimestamp"`
+	Count          int32           `json:"count"`
+}
+
+// Scale is the autoscaling/v1 scale subresource of a Deployment
+type Scale struct {
+	Metadata ObjectMeta  `json:"metadata"`
+	Spec     ScaleSpec   `json:"spec"`
+	Status   ScaleStatus `json:"status"`
+}
+
+// ScaleSpec is the desired number of replicas
+type ScaleSpec struct {
+	Replicas int32 `json:"replicas"`
+}
+
+// ScaleStatus is the number of replicas running
+type ScaleStatus struct {
+	Replicas int32 `json:"replicas"`
+}
+
+// Lease is a coordination.k8s.io/v1 Lease, held by one holder at a time
+type Lease struct {
+	APIVersion string     `json:"apiVersion,omitempty"`
+	Kind       string     `json:"kind,omitempty"`
+	Metadata   ObjectMeta `json:"metadata"`
+	Spec       LeaseSpec  `json:"spec"`
+}
+
+// LeaseSpec is who holds a Lease and until when
+type LeaseSpec struct {
+	HolderIdentity       string     `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int32      `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          *MicroTime `json:"acquireTime,omitempty"`
+	RenewTime            *MicroTime `json:"renewTime,omitempty"`
+	LeaseTransitions     int32      `json:"leaseTransitions,omitempty"`
+}
+
+// microTimeFormat is RFC3339 with microseconds, as used by Lease times
+const microTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// MicroTime is a time with microsecond precision
+type MicroTime struct {
+	time.Time
+}
+
+// NewMicroTime returns t as a MicroTime
+func NewMicroTime(t time.Time) *MicroTime {
+	return &MicroTime{Time: t.Truncate(time.Microsecond)}
+}
+
+// MarshalJSON writes the time with microseconds in UTC
+func (t MicroTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.UTC().Format(microTimeFormat))
+}
+
+// UnmarshalJSON reads the time with microseconds
+func (t *MicroTime) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	parsed, err := time.Parse(microTimeFormat, value)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
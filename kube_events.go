@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/openfaas-incubator/faas-idler/k8s"
+)
+
+const (
+	kubeEventScaledToZero      = "ScaledToZero"
+	kubeEventScaleToZeroFailed = "ScaleToZeroFailed"
+)
+
+// kubeEventSink records a Kubernetes Event on a function's Deployment when
+// it is scaled to zero, or fails to be, so that kubectl describe shows why
+type kubeEventSink struct {
+	client k8s.Interface
+	queue  chan Event
+	done   sync.WaitGroup
+
+	// backend is what scale requests are sent to
+	backend string
+
+	// namespace is where functions listed without one are
+	namespace string
+}
+
+func newKubeEventSink(client k8s.Interface, backend string, namespace string) *kubeEventSink {
+	k := &kubeEventSink{
+		client:    client,
+		queue:     make(chan Event, webhookQueueSize),
+		backend:   backend,
+		namespace: namespace,
+	}
+
+	k.done.Add(1)
+	go k.run()
+
+	return k
+}
+
+// Emit queues an event without blocking, events for functions whose
+// replicas didn't change are ignored
+func (k *kubeEventSink) Emit(event Event) error {
+	if event.DryRun || (event.Type != eventIdled && event.Type != eventScaleFailed) {
+		return nil
+	}
+
+	select {
+	case k.queue <- event:
+		return nil
+	default:
+		return fmt.Errorf("Kubernetes event queue full, dropping event")
+	}
+}
+
+// Close waits for queued events to be recorded
+func (k *kubeEventSink) Close() error {
+	close(k.queue)
+	k.done.Wait()
+	return nil
+}
+
+func (k *kubeEventSink) run() {
+	defer k.done.Done()
+
+	for event := range k.queue {
+		kubeEvent, namespace := kubeEventFor(event, k.backend, k.namespace)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := k.client.CreateEvent(ctx, namespace, kubeEvent)
+		cancel()
+
+		if err != nil {
+			log.Warn("unable to record Kubernetes event", "function", event.Function, "namespace", namespace, "err", err)
+		}
+	}
+}
+
+// kubeEventFor describes event against the function's Deployment, scaled
+// through backend, in functionNamespace when the event has no namespace
+func kubeEventFor(event Event, backend string, functionNamespace string) (*k8s.Event, string) {
+	namespace := event.Namespace
+	if len(namespace) == 0 {
+		namespace = functionNamespace
+	}
+
+	kubeEvent := &k8s.Event{
+		Metadata: k8s.ObjectMeta{
+			GenerateName: event.Function + ".",
+			Namespace:    namespace,
+		},
+		InvolvedObject: k8s.ObjectReference{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Namespace:  namespace,
+			Name:       event.Function,
+		},
+		Source:         k8s.EventSource{Component: "faas-idler"},
+		FirstTimestamp: event.Time,
+		LastTimestamp:  event.Time,
+		Count:          1,
+	}
+
+	if event.Type == eventScaleFailed {
+		kubeEvent.Type = k8s.EventTypeWarning
+		kubeEvent.Reason = kubeEventScaleToZeroFailed
+		kubeEvent.Message = fmt.Sprintf("idle, but the scale request to the %s failed", scaleTarget(backend))
+		return kubeEvent, namespace
+	}
+
+	kubeEvent.Type = k8s.EventTypeNormal
+	kubeEvent.Reason = kubeEventScaledToZero
+	switch {
+	case event.Decision != nil && event.Decision.Policy != nil:
+		kubeEvent.Message = fmt.Sprintf("no invocations for %s", time.Duration(event.Decision.Policy.InactivityDuration))
+	case len(event.Principal) > 0:
+		kubeEvent.Message = fmt.Sprintf("idled through the admin API by %s", event.Principal)
+	default:
+		kubeEvent.Message = string(event.Reason)
+	}
+	return kubeEvent, namespace
+}
+
+// scaleTarget names what backend sends scale requests to
+func scaleTarget(backend string) string {
+	switch backend {
+	case backendKubernetes:
+		return "Kubernetes API"
+	case backendSwarm:
+		return "Docker Engine"
+	}
+	return "gateway"
+}
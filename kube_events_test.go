@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openfaas-incubator/faas-idler/k8s"
+)
+
+func Test_KubeEventSinkRecordsScaledToZero(t *testing.T) {
+	client := k8s.NewFake()
+	sink := newKubeEventSink(client, backendGateway, "openfaas-fn")
+
+	idled := eventFor(Decision{
+		Time:      time.Now(),
+		Function:  "figlet",
+		Namespace: "openfaas-fn",
+		Action:    actionScale,
+		Reason:    ReasonIdle,
+		Policy:    &Policy{Label: scaleLabel + "=true", InactivityDuration: Duration(15 * time.Minute)},
+	})
+	skipped := eventFor(Decision{Function: "figlet", Action: actionSkip, Reason: ReasonCounterChanged})
+
+	dryRunIdled := idled
+	dryRunIdled.DryRun = true
+
+	sink.Emit(idled)
+	sink.Emit(skipped)
+	sink.Emit(dryRunIdled)
+	sink.Close()
+
+	events := client.Events()
+	if len(events) != 1 {
+		t.Fatalf("want 1 Kubernetes event, got: %d", len(events))
+	}
+
+	event := events[0]
+	if event.Reason != "ScaledToZero" || event.Message != "no invocations for 15m0s" {
+		t.Errorf("want ScaledToZero: no invocations for 15m0s, got: %s: %s", event.Reason, event.Message)
+	}
+	if event.InvolvedObject.Kind != "Deployment" || event.InvolvedObject.Name != "figlet" || event.Metadata.Namespace != "openfaas-fn" {
+		t.Errorf("want event on Deployment openfaas-fn/figlet, got: %+v in %s", event.InvolvedObject, event.Metadata.Namespace)
+	}
+}
+
+func Test_kubeEventForScaleFailed(t *testing.T) {
+	event, namespace := kubeEventFor(Event{Type: eventScaleFailed, Function: "figlet"}, backendGateway, "functions")
+
+	if event.Type != k8s.EventTypeWarning || event.Reason != "ScaleToZeroFailed" {
+		t.Errorf("want Warning ScaleToZeroFailed, got: %s %s", event.Type, event.Reason)
+	}
+	if namespace != "functions" || event.InvolvedObject.Namespace != "functions" {
+		t.Errorf("want event in function_namespace: functions, got: %s", namespace)
+	}
+	if want := "idle, but the scale request to the gateway failed"; event.Message != want {
+		t.Errorf("want message: %q, got: %q", want, event.Message)
+	}
+}
+
+func Test_kubeEventForScaleFailedByBackend(t *testing.T) {
+	event, _ := kubeEventFor(Event{Type: eventScaleFailed, Function: "figlet"}, backendKubernetes, "openfaas-fn")
+
+	if want := "idle, but the scale request to the Kubernetes API failed"; event.Message != want {
+		t.Errorf("want message: %q, got: %q", want, event.Message)
+	}
+}
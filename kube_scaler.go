@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openfaas-incubator/faas-idler/k8s"
+	providerTypes "github.com/openfaas/faas-provider/types"
+)
+
+const (
+	backendGateway    = "gateway"
+	backendKubernetes = "kubernetes"
+)
+
+// kubeScaler reads and scales functions through the scale subresource of
+// their Deployments, without going through the gateway
+type kubeScaler struct {
+	client k8s.Interface
+
+	// namespace is used for functions listed by the gateway without one
+	namespace string
+}
+
+func (k *kubeScaler) functionNamespace(namespace string) string {
+	if len(namespace) == 0 {
+		return k.namespace
+	}
+	return namespace
+}
+
+// GetReplicas reads the desired and running replicas of a function
+func (k *kubeScaler) GetReplicas(ctx context.Context, fn Function) (*providerTypes.FunctionStatus, error) {
+	namespace := k.functionNamespace(fn.Namespace)
+	scale, err := k.client.GetScale(ctx, namespace, fn.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &providerTypes.FunctionStatus{
+		Name:              fn.Name,
+		Namespace:         namespace,
+		Replicas:          uint64(scale.Spec.Replicas),
+		AvailableReplicas: uint64(scale.Status.Replicas),
+	}, nil
+}
+
+// Scale patches the desired replicas of a function
+func (k *kubeScaler) Scale(ctx context.Context, fn Function, replicas uint64) (scaleResponse, error) {
+	response := scaleResponse{}
+	namespace := k.functionNamespace(fn.Namespace)
+
+	start := time.Now()
+	_, err := k.client.UpdateScale(ctx, namespace, fn.Name, int32(replicas))
+	response.Latency = time.Since(start)
+
+	if err != nil {
+		if statusErr, ok := err.(*k8s.StatusError); ok {
+			response.StatusCode = statusErr.Code
+			scaleErrorsTotal.WithLabelValues(strconv.Itoa(statusErr.Code)).Inc()
+		} else {
+			scaleErrorsTotal.WithLabelValues("error").Inc()
+		}
+		return response, err
+	}
+
+	response.StatusCode = http.StatusOK
+	log.Info("scaled function", "function", fn.Name, "namespace", namespace, "replicas", replicas)
+	return response, nil
+}
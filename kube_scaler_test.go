@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/openfaas-incubator/faas-idler/k8s"
+)
+
+func Test_KubeScalerScalesDeployment(t *testing.T) {
+	client := k8s.NewFake()
+	client.AddDeployment("openfaas-fn", "figlet", 2)
+
+	scaler := &kubeScaler{client: client, namespace: "openfaas-fn"}
+
+	status, err := scaler.GetReplicas(context.Background(), Function{Name: "figlet"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Replicas != 2 || status.AvailableReplicas != 2 || status.Namespace != "openfaas-fn" {
+		t.Errorf("want 2 replicas in openfaas-fn, got: %+v", status)
+	}
+
+	if _, err := scaler.Scale(context.Background(), Function{Name: "figlet", Namespace: "openfaas-fn"}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	scale, _ := client.GetScale(context.Background(), "openfaas-fn", "figlet")
+	if scale.Spec.Replicas != 0 {
+		t.Errorf("want figlet scaled to 0, got: %d", scale.Spec.Replicas)
+	}
+}
+
+func Test_KubeScalerMissingDeployment(t *testing.T) {
+	scaler := &kubeScaler{client: k8s.NewFake(), namespace: "openfaas-fn"}
+
+	res, err := scaler.Scale(context.Background(), Function{Name: "missing"}, 0)
+	if err == nil {
+		t.Fatalf("want error for missing deployment")
+	}
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("want status code: %d, got: %d", http.StatusNotFound, res.StatusCode)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket which allows a burst of perMinute calls,
+// refilled at perMinute a minute
+type rateLimiter struct {
+	lock     sync.Mutex
+	capacity float64
+	tokens   float64
+	interval time.Duration
+	last     time.Time
+}
+
+// newRateLimiter returns nil, which never waits, when perMinute is 0
+func newRateLimiter(perMinute int) *rateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+
+	return &rateLimiter{
+		capacity: float64(perMinute),
+		tokens:   float64(perMinute),
+		interval: time.Minute / time.Duration(perMinute),
+		last:     time.Now(),
+	}
+}
+
+// Wait blocks until a call is allowed, or returns ctx's error when it is
+// cancelled first
+func (r *rateLimiter) Wait(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+
+	delay := r.reserve(time.Now())
+	if delay <= 0 {
+		return nil
+	}
+
+	if !sleep(ctx, delay) {
+		r.lock.Lock()
+		r.tokens++
+		r.lock.Unlock()
+		return ctx.Err()
+	}
+	return nil
+}
+
+// reserve takes a token and returns how long to wait before using it, the
+// bucket goes negative while calls are waiting
+func (r *rateLimiter) reserve(now time.Time) time.Duration {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.tokens += float64(now.Sub(r.last)) / float64(r.interval)
+	if r.tokens > r.capacity {
+		r.tokens = r.capacity
+	}
+	r.last = now
+
+	r.tokens--
+	if r.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-r.tokens * float64(r.interval))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_RateLimiterReserve(t *testing.T) {
+	limiter := newRateLimiter(2)
+	now := limiter.last
+
+	for i := 0; i < 2; i++ {
+		if delay := limiter.reserve(now); delay != 0 {
+			t.Fatalf("want the burst allowed straight away, got delay: %s", delay)
+		}
+	}
+
+	if delay := limiter.reserve(now); delay != 30*time.Second {
+		t.Errorf("want delay: %s, got: %s", 30*time.Second, delay)
+	}
+	if delay := limiter.reserve(now); delay != time.Minute {
+		t.Errorf("want delay: %s, got: %s", time.Minute, delay)
+	}
+
+	if delay := limiter.reserve(now.Add(2 * time.Minute)); delay != 0 {
+		t.Errorf("want the bucket refilled after a quiet period, got delay: %s", delay)
+	}
+}
+
+func Test_RateLimiterWaitCancelled(t *testing.T) {
+	limiter := newRateLimiter(1)
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := limiter.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("want: %s, got: %v", context.DeadlineExceeded, err)
+	}
+}
+
+func Test_RateLimiterDisabled(t *testing.T) {
+	if limiter := newRateLimiter(0); limiter != nil {
+		t.Errorf("want no limiter for 0 a minute")
+	}
+
+	var limiter *rateLimiter
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Errorf("want a nil limiter never to wait, got: %s", err)
+	}
+}
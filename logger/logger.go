@@ -0,0 +1,215 @@
+// Package logger writes leveled, structured log lines as logfmt or JSON.
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log line
+type Level int
+
+const (
+	// LevelDebug is for troubleshooting, enabled by write_debug
+	LevelDebug Level = iota
+	// LevelInfo is the default level
+	LevelInfo
+	// LevelWarn is for errors the idler recovers from
+	LevelWarn
+	// LevelError is for errors which stop the idler from working
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// Format is the encoding of each log line
+type Format string
+
+const (
+	// FormatLogfmt writes key=value pairs, the default
+	FormatLogfmt Format = "logfmt"
+	// FormatJSON writes one JSON object per line
+	FormatJSON Format = "json"
+)
+
+// ParseFormat validates a format name, empty means logfmt
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case "", FormatLogfmt:
+		return FormatLogfmt, nil
+	case FormatJSON:
+		return FormatJSON, nil
+	}
+	return "", fmt.Errorf("unknown log format: %s, use logfmt or json", name)
+}
+
+// Logger writes log lines at or above its level along with its fields,
+// loggers created by With share the same writer.
+type Logger struct {
+	out    io.Writer
+	lock   *sync.Mutex
+	format Format
+	level  Level
+	fields []interface{}
+}
+
+// New creates a Logger writing to out
+func New(out io.Writer, format Format, level Level) *Logger {
+	return &Logger{
+		out:    out,
+		lock:   &sync.Mutex{},
+		format: format,
+		level:  level,
+	}
+}
+
+// With returns a Logger which adds the key/value pairs to every line
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+
+	return &Logger{
+		out:    l.out,
+		lock:   l.lock,
+		format: l.format,
+		level:  l.level,
+		fields: fields,
+	}
+}
+
+// Enabled is true when lines at level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// Debug writes a line at LevelDebug
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.write(LevelDebug, msg, keyvals)
+}
+
+// Info writes a line at LevelInfo
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.write(LevelInfo, msg, keyvals)
+}
+
+// Warn writes a line at LevelWarn
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.write(LevelWarn, msg, keyvals)
+}
+
+// Error writes a line at LevelError
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.write(LevelError, msg, keyvals)
+}
+
+func (l *Logger) write(level Level, msg string, keyvals []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	kv := make([]interface{}, 0, 6+len(l.fields)+len(keyvals)+1)
+	kv = append(kv, "time", time.Now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", msg)
+	kv = append(kv, l.fields...)
+	kv = append(kv, keyvals...)
+	if len(kv)%2 != 0 {
+		kv = append(kv, "(MISSING)")
+	}
+
+	var buf bytes.Buffer
+	if l.format == FormatJSON {
+		writeJSON(&buf, kv)
+	} else {
+		writeLogfmt(&buf, kv)
+	}
+	buf.WriteByte('\n')
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.out.Write(buf.Bytes())
+}
+
+func writeLogfmt(buf *bytes.Buffer, kv []interface{}) {
+	for i := 0; i < len(kv); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(fmt.Sprint(kv[i]))
+		buf.WriteByte('=')
+
+		value := formatValue(kv[i+1])
+		if needsQuotes(value) {
+			value = strconv.Quote(value)
+		}
+		buf.WriteString(value)
+	}
+}
+
+func writeJSON(buf *bytes.Buffer, kv []interface{}) {
+	buf.WriteByte('{')
+	for i := 0; i < len(kv); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(kv[i]))
+		buf.Write(key)
+		buf.WriteByte(':')
+
+		var value interface{} = kv[i+1]
+		switch v := value.(type) {
+		case error, fmt.Stringer:
+			value = formatValue(v)
+		}
+
+		bytesOut, err := json.Marshal(value)
+		if err != nil {
+			bytesOut, _ = json.Marshal(formatValue(kv[i+1]))
+		}
+		buf.Write(bytesOut)
+	}
+	buf.WriteByte('}')
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
+
+func needsQuotes(value string) bool {
+	if len(value) == 0 {
+		return true
+	}
+	return strings.IndexFunc(value, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == 0x7f
+	}) != -1
+}
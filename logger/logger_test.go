@@ -0,0 +1,85 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_Logfmt(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log := New(buf, FormatLogfmt, LevelInfo).With("function", "figlet", "cycle", 3)
+
+	log.Info("scaled function", "reason", "no invocations", "err", errors.New("bad gateway"))
+
+	line := buf.String()
+	for _, want := range []string{
+		`level=info`,
+		`msg="scaled function"`,
+		`function=figlet`,
+		`cycle=3`,
+		`reason="no invocations"`,
+		`err="bad gateway"`,
+	} {
+		if !strings.Contains(line, want) {
+			t.Errorf("want %s in line: %s", want, line)
+		}
+	}
+
+	if !strings.HasSuffix(line, "\n") {
+		t.Errorf("want line to end with a newline: %q", line)
+	}
+}
+
+func Test_JSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log := New(buf, FormatJSON, LevelInfo).With("function", "figlet")
+
+	log.Warn("unable to scale", "replicas", 2, "err", errors.New("timeout"))
+
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &values); err != nil {
+		t.Fatalf("line is not JSON: %s, %s", err, buf.String())
+	}
+
+	want := map[string]interface{}{
+		"level":    "warn",
+		"msg":      "unable to scale",
+		"function": "figlet",
+		"replicas": float64(2),
+		"err":      "timeout",
+	}
+	for key, value := range want {
+		if values[key] != value {
+			t.Errorf("%s want: %v, got: %v", key, value, values[key])
+		}
+	}
+}
+
+func Test_Level(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log := New(buf, FormatLogfmt, LevelInfo)
+
+	log.Debug("hidden")
+	if buf.Len() != 0 {
+		t.Errorf("want debug lines to be dropped at info level, got: %s", buf.String())
+	}
+
+	log = New(buf, FormatLogfmt, LevelDebug)
+	log.Debug("shown")
+	if !strings.Contains(buf.String(), "msg=shown") {
+		t.Errorf("want debug line at debug level, got: %s", buf.String())
+	}
+}
+
+func Test_ParseFormat(t *testing.T) {
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Errorf("want error for unknown format")
+	}
+
+	if format, _ := ParseFormat(""); format != FormatLogfmt {
+		t.Errorf("want logfmt by default, got: %s", format)
+	}
+}
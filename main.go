@@ -1,21 +1,21 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/openfaas-incubator/faas-idler/docker"
+	"github.com/openfaas-incubator/faas-idler/k8s"
+	"github.com/openfaas-incubator/faas-idler/logger"
 	"github.com/openfaas-incubator/faas-idler/types"
 
 	providerTypes "github.com/openfaas/faas-provider/types"
@@ -23,16 +23,29 @@ import (
 )
 
 const scaleLabel = "com.openfaas.scale.zero"
+
+// gatewayMetricsURL is where the gateway's invocation counters are scraped
+const gatewayMetricsURL = "http://gateway-metrics:8082/metrics"
 const prometheusScrapeInterval = 15
 
 var dryRun bool
 
 var writeDebug bool
 
-var functionTouches = make(map[string]float64)
+var state = newIdlerState()
+
+var audit = &auditor{}
+
+var notifications = &notifier{}
 
-// var firstChecks = make(map[string]float64)
-// var secondChecks = make(map[string]float64)
+var log = logger.New(os.Stderr, logger.FormatLogfmt, logger.LevelInfo)
+
+// metricsClient scrapes the gateway's metrics, its timeout is set from
+// gateway_timeout
+var metricsClient = &http.Client{Timeout: 10 * time.Second}
+
+// cycles counts reconcile cycles, the count identifies a cycle in the logs
+var cycles uint64
 
 type Credentials struct {
 	Username string
@@ -40,96 +53,280 @@ type Credentials struct {
 }
 
 func main() {
+	command := "run"
+	args := os.Args[1:]
+
+	// Flags without a sub-command, i.e. "faas-idler -dry-run=false", keep
+	// working as "run" for existing deployments.
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command = args[0]
+		args = args[1:]
+	}
+
+	if val, ok := os.LookupEnv("write_debug"); ok && (val == "1" || val == "true") {
+		writeDebug = true
+	}
+
+	logFormat, err := logger.ParseFormat(os.Getenv("log_format"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logLevel := logger.LevelInfo
+	if writeDebug {
+		logLevel = logger.LevelDebug
+	}
+	log = logger.New(os.Stderr, logFormat, logLevel)
+
+	switch command {
+	case "run":
+		err = runCommand(args)
+	case "once":
+		err = onceCommand(args)
+	case "plan":
+		err = planCommand(args)
+	case "status":
+		err = statusCommand(args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n%s", command, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Error("command failed", "command", command, "err", err)
+		os.Exit(1)
+	}
+}
+
+// setup reads the configuration and credentials and checks the gateway
+// can be reached, which every command apart from status needs. The
+// controller scales through the configured backend, or only records scale
+// requests in dry-run. notify registers the webhook, CloudEvents and
+// Kubernetes Event sinks, which plan leaves out so that nothing is
+// published about a cycle run from a laptop.
+func setup(notify bool) (*Controller, *Credentials, error) {
 	config, configErr := types.ReadConfig()
 	if configErr != nil {
-		log.Panic(configErr.Error())
-		os.Exit(1)
+		return nil, nil, configErr
 	}
 
-	flag.BoolVar(&dryRun, "dry-run", false, "use dry-run for scaling events")
-	flag.Parse()
+	for _, exemption := range config.Exemptions {
+		err := state.exemptions.Add(Exemption{
+			Pattern: exemption.Pattern,
+			Until:   exemption.Until,
+			Source:  exemptionSourceConfig,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("exemption %s: %s", exemption.Pattern, err)
+		}
+	}
 
-	if val, ok := os.LookupEnv("write_debug"); ok && (val == "1" || val == "true") {
-		writeDebug = true
+	if len(config.AuditLogFile) > 0 {
+		sink, err := newFileAuditSink(config.AuditLogFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("audit log: %s", err)
+		}
+		audit.add(sink)
 	}
 
-	credentials := Credentials{}
+	if len(config.AuditWebhookURL) > 0 {
+		audit.add(newWebhookAuditSink(config.AuditWebhookURL))
+	}
 
-	secretMountPath := "/var/secrets/"
-	if val, ok := os.LookupEnv("secret_mount_path"); ok && len(val) > 0 {
-		secretMountPath = val
+	if !notify {
+		config.WebhookURLs = nil
+		config.CloudEventsURL = ""
+		config.KubernetesEvents = false
+	}
+
+	for _, url := range config.WebhookURLs {
+		webhook, err := newWebhookNotifier(url, config.WebhookFormat, webhookEvents...)
+		if err != nil {
+			return nil, nil, err
+		}
+		notifications.add(webhook)
 	}
 
-	if val, err := readFile(path.Join(secretMountPath, "basic-auth-user")); err == nil {
+	if len(config.CloudEventsURL) > 0 {
+		sink, err := newCloudEventSink(config.CloudEventsURL, config.CloudEventsTypes...)
+		if err != nil {
+			return nil, nil, err
+		}
+		notifications.add(sink)
+	}
+
+	var kubeClient k8s.Interface
+	if config.KubernetesEvents || config.Backend == backendKubernetes || config.LeaderElection == electionKubernetes {
+		kubeConfig, err := k8s.InClusterConfig()
+		if err != nil {
+			return nil, nil, fmt.Errorf("kubernetes: %s", err)
+		}
+
+		if kubeClient, err = k8s.NewForConfig(kubeConfig); err != nil {
+			return nil, nil, fmt.Errorf("kubernetes: %s", err)
+		}
+	}
+
+	if config.KubernetesEvents {
+		notifications.add(newKubeEventSink(kubeClient, config.Backend, config.FunctionNamespace))
+	}
+
+	credentials := Credentials{}
+
+	if val, err := readFile(path.Join(secretMountPath(), "basic-auth-user")); err == nil {
 		credentials.Username = val
 	} else {
-		log.Printf("Unable to read username: %s", err)
+		log.Warn("unable to read username", "err", err)
 	}
 
-	if val, err := readFile(path.Join(secretMountPath, "basic-auth-password")); err == nil {
+	if val, err := readFile(path.Join(secretMountPath(), "basic-auth-password")); err == nil {
 		credentials.Password = val
 	} else {
-		log.Printf("Unable to read password: %s", err)
+		log.Warn("unable to read password", "err", err)
 	}
 
-	client := &http.Client{}
-	version, err := getVersion(client, config.GatewayURL, &credentials)
+	client := &http.Client{Timeout: config.GatewayTimeout}
+	metricsClient.Timeout = config.GatewayTimeout
 
+	version, err := getVersion(context.Background(), client, config.GatewayURL, &credentials)
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
 
-	log.Printf("Gateway version: %s, SHA: %s\n", version.Version.Release, version.Version.SHA)
+	log.Info("gateway version", "release", version.Version.Release, "sha", version.Version.SHA)
 
-	fmt.Printf(`dry_run: %t
-gateway_url: %s
-inactivity_duration: %s
-reconcile_interval: %s
-`, dryRun, config.GatewayURL, config.InactivityDuration, config.ReconcileInterval)
+	gateway := &gatewayScaler{
+		client:      client,
+		gatewayURL:  config.GatewayURL,
+		credentials: &credentials,
+		retries:     config.GatewayRetries,
+		backoff:     gatewayBackoff,
+		breaker:     newCircuitBreaker(config.CircuitBreakerFailures, config.CircuitBreakerCooldown),
+	}
 
-	if len(config.GatewayURL) == 0 {
-		fmt.Println("gateway_url (faas-netes/faas-swarm) is required.")
-		os.Exit(1)
+	var lister FunctionLister = gateway
+	var scaler Scaler = gateway
+
+	switch config.Backend {
+	case backendKubernetes:
+		scaler = &kubeScaler{client: kubeClient, namespace: config.FunctionNamespace}
+	case backendSwarm:
+		dockerClient, err := docker.NewClient(config.DockerHost)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		swarm := &swarmScaler{client: dockerClient}
+		lister = swarm
+		scaler = swarm
+	}
+
+	if dryRun {
+		scaler = newDryRunScaler(scaler)
+	}
+
+	log.Info("configuration",
+		"dry_run", dryRun,
+		"gateway_url", config.GatewayURL,
+		"backend", config.Backend,
+		"gateway_timeout", config.GatewayTimeout,
+		"leader_election", config.LeaderElection,
+		"shard", fmt.Sprintf("%d/%d", config.ShardIndex, config.ShardCount),
+		"inactivity_duration", config.InactivityDuration,
+		"reconcile_interval", config.ReconcileInterval)
+
+	controller := newController(config, lister, scaler)
+
+	if config.StalenessThreshold > 0 && len(config.GatewayJob) > 0 {
+		query := metrics.NewPrometheusQuery(config.PrometheusHost, config.PrometheusPort, metricsClient)
+		if scraped, err := prometheusJobScraped(query, config.GatewayJob); err != nil {
+			log.Warn("unable to check the gateway's Prometheus job", "gateway_job", config.GatewayJob, "err", err)
+		} else if !scraped {
+			log.Error("Prometheus has no targets for gateway_job, every idle function will be skipped as stale-metrics",
+				"gateway_job", config.GatewayJob)
+		}
 	}
 
-	for {
-		// fmt.Println("===== started =====")
-		reconcile(client, config, &credentials)
-		// time.Sleep(config.ReconcileInterval)
+	// each shard elects its own leader
+	if config.ShardCount > 1 {
+		config.LeaseName = fmt.Sprintf("%s-%d", config.LeaseName, config.ShardIndex)
 	}
+
+	identity := electionIdentity()
+	switch config.LeaderElection {
+	case electionKubernetes:
+		lock := newKubeLeaseLock(kubeClient, config.LeaseNamespace, config.LeaseName, identity, config.LeaseDuration)
+		controller.elector = newLeaderElector(lock, identity, config.LeaseDuration)
+	case electionFile:
+		controller.elector = newLeaderElector(newFileLock(config.LeaseName), identity, config.LeaseDuration)
+	}
+
+	return controller, &credentials, nil
+}
+
+// secretMountPath is the directory secrets are read from
+func secretMountPath() string {
+	if val, ok := os.LookupEnv("secret_mount_path"); ok && len(val) > 0 {
+		return val
+	}
+	return "/var/secrets/"
 }
 
 // Get RESTful get
-func Get(url string) (int, []byte) {
+func Get(ctx context.Context, url string) (int, []byte) {
 	var err error
 	var body []byte
-	resp, err := http.Get(url)
+
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	resp, err := metricsClient.Do(req.WithContext(ctx))
 	if err != nil {
-		fmt.Printf("httpGet error\t%v", err)
+		metricsSourceErrorsTotal.Inc()
+		log.Warn("unable to get metrics", "url", url, "err", err)
 		// clean up and return the function
 		return 0, body
 	}
 	defer resp.Body.Close()
 	body, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("ReadAll error\t%v", err)
+		metricsSourceErrorsTotal.Inc()
+		log.Warn("unable to read metrics", "url", url, "err", err)
 	}
 	return resp.StatusCode, body
 }
 
-func gatewayFunctionInvocationTotal(functionName string) float64 {
+// gatewayFunctionInvocationTotal returns the invocations of a function and
+// when they were sampled, which is the latest timestamp on its rows when the
+// metrics carry them, as a federating Prometheus does, or else now
+func gatewayFunctionInvocationTotal(ctx context.Context, functionName string) (float64, time.Time, error) {
 	// TODO: Parsing metrics
 	// gateway_function_invocation_total{code="200",function_name="sethostsport"} 16
 
-	_url := "http://gateway-metrics:8082/metrics"
+	_url := gatewayMetricsURL
 	//	_url = "http://localhost:8082/metrics"
 	//	fmt.Println(_url)
-	_, dataStr := Get(_url)
+	code, dataStr := Get(ctx, _url)
+	if code == 0 {
+		return 0, time.Time{}, fmt.Errorf("unable to get metrics from %s", _url)
+	}
+	if code != http.StatusOK {
+		metricsSourceErrorsTotal.Inc()
+		return 0, time.Time{}, fmt.Errorf("unexpected status code for metrics: %d", code)
+	}
+	return parseInvocationTotal(string(dataStr), functionName, time.Now())
+}
+
+// parseInvocationTotal sums the invocations of a function from the
+// Prometheus text format, read at now
+func parseInvocationTotal(dataStr string, functionName string, now time.Time) (float64, time.Time, error) {
 	//	fmt.Println(string(_dataStr))
-	_data := strings.Split(string(dataStr), "\n")
+	_data := strings.Split(dataStr, "\n")
 
 	var _sum int
 	_sum = 0
+	var sampled time.Time
 
 	for _, row := range _data {
 		//		fmt.Println(strings.HasPrefix(row, "gateway_function_invocation_total"))
@@ -153,8 +350,17 @@ func gatewayFunctionInvocationTotal(functionName string) float64 {
 		_segs := strings.Split(row, " ")
 		_hits, err := strconv.Atoi(_segs[1])
 		if err != nil {
-			fmt.Println("Warn) failed to parse, skip:", _segs[1])
-			continue
+			return 0, time.Time{}, fmt.Errorf("unable to parse metric: %s", row)
+		}
+
+		if len(_segs) > 2 {
+			ms, err := strconv.ParseInt(_segs[2], 10, 64)
+			if err != nil {
+				return 0, time.Time{}, fmt.Errorf("unable to parse metric: %s", row)
+			}
+			if at := time.Unix(0, ms*int64(time.Millisecond)); at.After(sampled) {
+				sampled = at
+			}
 		}
 		// fmt.Println(">", _segs[1], _hits)
 
@@ -162,7 +368,11 @@ func gatewayFunctionInvocationTotal(functionName string) float64 {
 
 		//		fmt.Println(">", row, "<", strings.HasPrefix(row, "gateway_function_invocation_total"))
 	}
-	return float64(_sum)
+
+	if sampled.IsZero() {
+		sampled = now
+	}
+	return float64(_sum), sampled, nil
 }
 
 func readFile(path string) (string, error) {
@@ -183,10 +393,11 @@ func buildMetricsMap(client *http.Client, functions []providerTypes.FunctionStat
 	for _, function := range functions {
 		querySt := url.QueryEscape(`sum(rate(gateway_function_invocation_total{function_name="` + function.Name + `", code=~".*"}[` + duration + `])) by (code, function_name)`)
 
-		fmt.Printf(querySt)
+		log.Debug("querying prometheus", "function", function.Name, "query", querySt)
 		res, err := query.Fetch(querySt)
 		if err != nil {
-			log.Println(err)
+			metricsSourceErrorsTotal.Inc()
+			log.Warn("unable to query prometheus", "function", function.Name, "err", err)
 			continue
 		}
 
@@ -198,9 +409,7 @@ func buildMetricsMap(client *http.Client, functions []providerTypes.FunctionStat
 
 			for _, v := range res.Data.Result {
 
-				if writeDebug {
-					fmt.Println(v)
-				}
+				log.Debug("prometheus result", "function", function.Name, "result", fmt.Sprint(v))
 
 				if v.Metric.FunctionName == function.Name {
 					metricValue := v.Value[1]
@@ -209,7 +418,7 @@ func buildMetricsMap(client *http.Client, functions []providerTypes.FunctionStat
 
 						f, strconvErr := strconv.ParseFloat(metricValue.(string), 64)
 						if strconvErr != nil {
-							log.Printf("Unable to convert value for metric: %s\n", strconvErr)
+							log.Warn("unable to convert value for metric", "function", function.Name, "err", strconvErr)
 							continue
 						}
 
@@ -222,159 +431,6 @@ func buildMetricsMap(client *http.Client, functions []providerTypes.FunctionStat
 	return metrics
 }
 
-func reconcile(client *http.Client, config types.Config, credentials *Credentials) {
-	functions, err := queryFunctions(client, config.GatewayURL, credentials)
-
-	if err != nil {
-		log.Println("Warn)", err)
-		return
-	}
-	// fmt.Println("Debug)", "function list fetched")
-
-	var wg sync.WaitGroup
-	// wg.Add(len(functions))
-	for _, function := range functions {
-
-		// fmt.Printf("Info) %v\n", function)
-		wg.Add(1)
-
-		go func(client *http.Client, function providerTypes.FunctionStatus, config types.Config, credentials *Credentials, wg *sync.WaitGroup) {
-			defer wg.Done()
-			// Criteria 1: skip thouse no lables
-			if function.Labels != nil {
-				labels := *function.Labels
-				labelValue := labels[scaleLabel]
-
-				if labelValue != "1" && labelValue != "true" {
-					if writeDebug {
-						log.Printf("Skip: %s due to missing label\n", function.Name)
-						// fmt.Printf("Skip: %s due to missing label\n", function.Name)
-					}
-					// fmt.Printf("Info) %s is not labeled, skip the pod...\n", function.Name)
-					return
-				}
-			}
-
-			// layout := "January 02, 2006 Mon 3:04:05 PM MST"
-			layout := "2006-01-02 03:04:05 PM"
-
-			// generate initial map
-			if _, ok := functionTouches[function.Name]; !ok {
-				// functionTouches[function.Name] = -1
-				functionTouches[function.Name] = gatewayFunctionInvocationTotal(function.Name)
-				fmt.Printf("Cache Init\t%v\tlastCache\t%s\t%f\n", time.Now().Format(layout), function.Name, functionTouches[function.Name])
-				return
-			}
-
-			if val, _ := getReplicas(client, config.GatewayURL, function.Name, credentials); val != nil && val.AvailableReplicas > 0 {
-
-				firstCheck := gatewayFunctionInvocationTotal(function.Name)
-				// fmt.Printf("%v\t1st check\t%s\t%f\t%f\n", time.Now().Format(layout), function.Name, functionTouches[function.Name], firstCheck)
-
-				time.Sleep(config.InactivityDuration)
-
-				secondCheck := gatewayFunctionInvocationTotal(function.Name)
-				// fmt.Printf("%v\t2nd check\t%s\t%f\t%f\t%f\n", time.Now().Format(layout), function.Name, functionTouches[function.Name], firstCheck, secondCheck)
-
-				if secondCheck == firstCheck && secondCheck == functionTouches[function.Name] {
-					// Idles InactivityDuration, scales to zero
-					// fmt.Printf("**** SCALE TO ZERO *****\t%v\tvalMap\tretvalBefore\tretvalAfter\t%v\t%v\t%v\n", function.Name, functionTouches[function.Name], firstCheck, secondCheck)
-					sendScaleEvent(client, config.GatewayURL, function.Name, uint64(0), credentials)
-				}
-			} else {
-				time.Sleep(config.InactivityDuration)
-			}
-
-			// update cache with latest check value
-			functionTouches[function.Name] = gatewayFunctionInvocationTotal(function.Name)
-			// fmt.Printf("Update\t%v\tlastCache\t%s\t%f\n", time.Now().Format(layout), function.Name, functionTouches[function.Name])
-
-		}(client, function, config, credentials, &wg)
-	}
-
-	wg.Wait()
-	// fmt.Println("all functions are done...")
-	// fmt.Println("ONE ROUND OVER ===================================== ")
-}
-
-func getReplicas(client *http.Client, gatewayURL string, name string, credentials *Credentials) (*providerTypes.FunctionStatus, error) {
-	item := &providerTypes.FunctionStatus{}
-	var err error
-
-	req, _ := http.NewRequest(http.MethodGet, gatewayURL+"system/function/"+name, nil)
-	req.SetBasicAuth(credentials.Username, credentials.Password)
-
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	if res.Body != nil {
-		defer res.Body.Close()
-	}
-
-	bytesOut, _ := ioutil.ReadAll(res.Body)
-
-	err = json.Unmarshal(bytesOut, &item)
-
-	return item, err
-}
-
-func queryFunctions(client *http.Client, gatewayURL string, credentials *Credentials) ([]providerTypes.FunctionStatus, error) {
-	list := []providerTypes.FunctionStatus{}
-	var err error
-
-	req, _ := http.NewRequest(http.MethodGet, gatewayURL+"system/functions", nil)
-	req.SetBasicAuth(credentials.Username, credentials.Password)
-
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	if res.Body != nil {
-		defer res.Body.Close()
-	}
-
-	bytesOut, _ := ioutil.ReadAll(res.Body)
-
-	err = json.Unmarshal(bytesOut, &list)
-
-	return list, err
-}
-
-func sendScaleEvent(client *http.Client, gatewayURL string, name string, replicas uint64, credentials *Credentials) {
-	if dryRun {
-		fmt.Printf("dry-run: Scaling %s to %d replicas\n", name, replicas)
-		return
-	}
-
-	scaleReq := providerTypes.ScaleServiceRequest{
-		ServiceName: name,
-		Replicas:    replicas,
-	}
-
-	var err error
-
-	bodyBytes, _ := json.Marshal(scaleReq)
-	bodyReader := bytes.NewReader(bodyBytes)
-
-	req, _ := http.NewRequest(http.MethodPost, gatewayURL+"system/scale-function/"+name, bodyReader)
-	req.SetBasicAuth(credentials.Username, credentials.Password)
-
-	res, err := client.Do(req)
-
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	log.Println("Scale", name, res.StatusCode, replicas)
-
-	if res.Body != nil {
-		defer res.Body.Close()
-	}
-}
-
 // Version holds the GitHub Release and SHA
 type Version struct {
 	Version struct {
@@ -383,11 +439,12 @@ type Version struct {
 	}
 }
 
-func getVersion(client *http.Client, gatewayURL string, credentials *Credentials) (Version, error) {
+func getVersion(ctx context.Context, client *http.Client, gatewayURL string, credentials *Credentials) (Version, error) {
 	version := Version{}
 	var err error
 
 	req, _ := http.NewRequest(http.MethodGet, gatewayURL+"system/info", nil)
+	req = req.WithContext(ctx)
 	req.SetBasicAuth(credentials.Username, credentials.Password)
 
 	res, err := client.Do(req)
@@ -399,6 +456,10 @@ func getVersion(client *http.Client, gatewayURL string, credentials *Credentials
 		defer res.Body.Close()
 	}
 
+	if res.StatusCode != http.StatusOK {
+		return version, &gatewayStatusError{Code: res.StatusCode}
+	}
+
 	bytesOut, _ := ioutil.ReadAll(res.Body)
 
 	err = json.Unmarshal(bytesOut, &version)
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// eventIdled a function was scaled to zero
+	eventIdled = "idled"
+	// eventWoken a function was scaled up from zero through the admin API
+	eventWoken = "woken"
+	// eventSkipped a function was evaluated and left as it was
+	eventSkipped = "skipped"
+	// eventScaleFailed a function was idle, but could not be scaled to zero
+	eventScaleFailed = "scale-failed"
+	// eventUnevaluated a function could not be evaluated
+	eventUnevaluated = "unevaluated"
+)
+
+const (
+	webhookFormatJSON        = "json"
+	webhookFormatSlack       = "slack"
+	webhookFormatCloudEvents = "cloudevents"
+)
+
+const (
+	webhookQueueSize = 100
+	webhookRetries   = 3
+	webhookBackoff   = time.Second
+)
+
+// webhookEvents are the events sent to webhook_urls
+var webhookEvents = []string{eventIdled, eventScaleFailed, eventUnevaluated}
+
+// Event is something which happened to a function, either during a cycle
+// or through the admin API
+type Event struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Function  string    `json:"function"`
+	Namespace string    `json:"namespace,omitempty"`
+	Reason    Reason    `json:"reason"`
+	Replicas  *uint64   `json:"replicas,omitempty"`
+	Principal string    `json:"principal,omitempty"`
+	DryRun    bool      `json:"dryRun"`
+	Decision  *Decision `json:"decision,omitempty"`
+}
+
+// eventFor returns the event for a decision
+func eventFor(decision Decision) Event {
+	event := Event{
+		Type:      eventSkipped,
+		Time:      decision.Time,
+		Function:  decision.Function,
+		Namespace: decision.Namespace,
+		Reason:    decision.Reason,
+		DryRun:    decision.DryRun,
+		Decision:  &decision,
+	}
+
+	switch {
+	case decision.Action == actionScale:
+		var replicas uint64
+		event.Type = eventIdled
+		event.Replicas = &replicas
+	case decision.Reason == ReasonScaleFailed:
+		event.Type = eventScaleFailed
+	case decision.Reason == ReasonReplicasUnknown || decision.Reason == ReasonInvocationsUnknown || decision.Reason == ReasonCircuitOpen:
+		event.Type = eventUnevaluated
+	}
+	return event
+}
+
+// EventSink receives events alongside the idler's logs, Emit must not
+// block reconcile
+type EventSink interface {
+	Emit(event Event) error
+	Close() error
+}
+
+// notifier sends each event to every sink
+type notifier struct {
+	sinks []EventSink
+}
+
+func (n *notifier) add(sink EventSink) {
+	n.sinks = append(n.sinks, sink)
+}
+
+// Emit sends event to every sink, failures are logged so that a sink never
+// stops scaling
+func (n *notifier) Emit(event Event) {
+	for _, sink := range n.sinks {
+		if err := sink.Emit(event); err != nil {
+			log.Warn("unable to emit event", "function", event.Function, "event", event.Type, "err", err)
+		}
+	}
+}
+
+// Close waits for every sink to deliver what it has queued
+func (n *notifier) Close() {
+	for _, sink := range n.sinks {
+		if err := sink.Close(); err != nil {
+			log.Warn("unable to close event sink", "err", err)
+		}
+	}
+}
+
+// webhookNotifier POSTs events to a URL from a bounded queue, so that a
+// slow receiver never holds up reconcile. Events are dropped when the
+// queue is full.
+type webhookNotifier struct {
+	url     string
+	format  string
+	events  map[string]bool
+	client  *http.Client
+	queue   chan Event
+	retries int
+	backoff time.Duration
+	done    sync.WaitGroup
+
+	// ignoredReasons are decisions never sent
+	ignoredReasons map[Reason]bool
+
+	// typeOf is the type events are filtered on, the event's own type
+	// when nil
+	typeOf func(event Event) string
+
+	// dropped counts events dropped since the last warning, which is
+	// logged at most every dropWarningInterval
+	dropLock    sync.Mutex
+	dropped     int
+	lastDropped time.Time
+}
+
+// dropWarningInterval is how often dropped webhook events are warned about
+const dropWarningInterval = time.Minute
+
+// newWebhookNotifier sends the given types of event to url, or every event
+// when none are given
+func newWebhookNotifier(url string, format string, events ...string) (*webhookNotifier, error) {
+	switch format {
+	case webhookFormatJSON, webhookFormatSlack, webhookFormatCloudEvents:
+	default:
+		return nil, fmt.Errorf("unknown webhook format: %s, use json, slack or cloudevents", format)
+	}
+
+	n := &webhookNotifier{
+		url:     url,
+		format:  format,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		queue:   make(chan Event, webhookQueueSize),
+		retries: webhookRetries,
+		backoff: webhookBackoff,
+	}
+
+	if len(events) > 0 {
+		n.events = map[string]bool{}
+		for _, event := range events {
+			n.events[event] = true
+		}
+	}
+
+	n.done.Add(1)
+	go n.run()
+
+	return n, nil
+}
+
+// Emit queues an event without blocking
+func (n *webhookNotifier) Emit(event Event) error {
+	eventType := event.Type
+	if n.typeOf != nil {
+		eventType = n.typeOf(event)
+	}
+	if n.events != nil && !n.events[eventType] {
+		return nil
+	}
+	if n.ignoredReasons[event.Reason] && event.Type == eventSkipped {
+		return nil
+	}
+
+	select {
+	case n.queue <- event:
+		return nil
+	default:
+		webhookEventsDroppedTotal.Inc()
+		return n.dropWarning(time.Now())
+	}
+}
+
+// dropWarning returns an error for dropped events once per
+// dropWarningInterval, counting those dropped in between
+func (n *webhookNotifier) dropWarning(now time.Time) error {
+	n.dropLock.Lock()
+	defer n.dropLock.Unlock()
+
+	n.dropped++
+	if now.Sub(n.lastDropped) < dropWarningInterval {
+		return nil
+	}
+
+	err := fmt.Errorf("webhook queue full for %s, dropped %d events", n.url, n.dropped)
+	n.dropped = 0
+	n.lastDropped = now
+	return err
+}
+
+// Close stops accepting events and waits for the queue to be delivered
+func (n *webhookNotifier) Close() error {
+	close(n.queue)
+	n.done.Wait()
+	return nil
+}
+
+func (n *webhookNotifier) run() {
+	defer n.done.Done()
+
+	for event := range n.queue {
+		if err := n.deliver(event); err != nil {
+			webhookDeliveryErrorsTotal.Inc()
+			log.Warn("unable to deliver webhook", "url", n.url, "function", event.Function, "event", event.Type, "err", err)
+		}
+	}
+}
+
+// deliver POSTs an event, retrying with exponential backoff when the
+// receiver can't be reached or returns a server error
+func (n *webhookNotifier) deliver(event Event) error {
+	body, contentType, err := n.payload(event)
+	if err != nil {
+		return err
+	}
+
+	backoff := n.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := n.post(body, contentType)
+		if err == nil {
+			return nil
+		}
+
+		if !retry || attempt >= n.retries {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff = backoff * 2
+	}
+}
+
+func (n *webhookNotifier) post(body []byte, contentType string) (bool, error) {
+	res, err := n.client.Post(n.url, contentType, bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode >= http.StatusOK && res.StatusCode < http.StatusMultipleChoices:
+		return false, nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError:
+		return true, fmt.Errorf("unexpected status code from webhook: %d", res.StatusCode)
+	}
+	return false, fmt.Errorf("unexpected status code from webhook: %d", res.StatusCode)
+}
+
+// payload encodes an event in the notifier's format
+func (n *webhookNotifier) payload(event Event) ([]byte, string, error) {
+	switch n.format {
+	case webhookFormatSlack:
+		body, err := json.Marshal(map[string]string{"text": slackText(event)})
+		return body, "application/json", err
+
+	case webhookFormatCloudEvents:
+		return cloudEventPayload(event)
+	}
+
+	body, err := json.Marshal(event)
+	return body, "application/json", err
+}
+
+func slackText(event Event) string {
+	name := event.Function
+	if len(event.Namespace) > 0 {
+		name = name + "." + event.Namespace
+	}
+
+	prefix := ""
+	if event.DryRun {
+		prefix = "[dry-run] "
+	}
+
+	switch event.Type {
+	case eventIdled:
+		return fmt.Sprintf("%sfaas-idler scaled `%s` to zero: %s", prefix, name, event.Reason)
+	case eventScaleFailed:
+		return fmt.Sprintf("%sfaas-idler failed to scale `%s` to zero", prefix, name)
+	case eventUnevaluated:
+		return fmt.Sprintf("%sfaas-idler could not evaluate `%s`: %s", prefix, name, event.Reason)
+	case eventWoken:
+		return fmt.Sprintf("%sfaas-idler woke `%s`: %s", prefix, name, event.Reason)
+	}
+	return fmt.Sprintf("%sfaas-idler %s `%s`: %s", prefix, strings.Replace(event.Type, "-", " ", -1), name, event.Reason)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_eventFor(t *testing.T) {
+	cases := []struct {
+		name     string
+		decision Decision
+		wantType string
+	}{
+		{name: "idled", decision: Decision{Action: actionScale, Reason: ReasonIdle}, wantType: eventIdled},
+		{name: "scale failed", decision: Decision{Action: actionSkip, Reason: ReasonScaleFailed}, wantType: eventScaleFailed},
+		{name: "replicas unknown", decision: Decision{Action: actionSkip, Reason: ReasonReplicasUnknown}, wantType: eventUnevaluated},
+		{name: "counter changed", decision: Decision{Action: actionSkip, Reason: ReasonCounterChanged}, wantType: eventSkipped},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			event := eventFor(c.decision)
+			if event.Type != c.wantType {
+				t.Errorf("want type: %s, got: %s", c.wantType, event.Type)
+			}
+		})
+	}
+}
+
+type receivedWebhook struct {
+	contentType string
+	body        []byte
+}
+
+func newTestReceiver(statuses ...int) (*httptest.Server, func() []receivedWebhook) {
+	var lock sync.Mutex
+	received := []receivedWebhook{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		lock.Lock()
+		attempt := len(received)
+		received = append(received, receivedWebhook{contentType: r.Header.Get("Content-Type"), body: body})
+		lock.Unlock()
+
+		if attempt < len(statuses) {
+			w.WriteHeader(statuses[attempt])
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	return server, func() []receivedWebhook {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]receivedWebhook{}, received...)
+	}
+}
+
+func testEvent() Event {
+	return Event{
+		Type:      eventIdled,
+		Time:      time.Now(),
+		Function:  "figlet",
+		Namespace: "openfaas-fn",
+		Reason:    ReasonIdle,
+	}
+}
+
+func Test_WebhookFormats(t *testing.T) {
+	cases := []struct {
+		format      string
+		contentType string
+		check       func(t *testing.T, body map[string]interface{})
+	}{
+		{
+			format:      webhookFormatJSON,
+			contentType: "application/json",
+			check: func(t *testing.T, body map[string]interface{}) {
+				if body["function"] != "figlet" || body["type"] != eventIdled {
+					t.Errorf("want figlet idled, got: %v", body)
+				}
+			},
+		},
+		{
+			format:      webhookFormatSlack,
+			contentType: "application/json",
+			check: func(t *testing.T, body map[string]interface{}) {
+				text, _ := body["text"].(string)
+				if !strings.Contains(text, "figlet") {
+					t.Errorf("want text to name figlet, got: %q", text)
+				}
+			},
+		},
+		{
+			format:      webhookFormatCloudEvents,
+			contentType: "application/cloudevents+json",
+			check: func(t *testing.T, body map[string]interface{}) {
+				if body["specversion"] != "1.0" || body["type"] != "com.openfaas.idler.function.idled" {
+					t.Errorf("want a CloudEvent of type com.openfaas.idler.function.idled, got: %v", body)
+				}
+				if id, _ := body["id"].(string); len(id) == 0 {
+					t.Errorf("want an id")
+				}
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.format, func(t *testing.T) {
+			receiver, received := newTestReceiver()
+			defer receiver.Close()
+
+			webhook, err := newWebhookNotifier(receiver.URL, c.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			webhook.Emit(testEvent())
+			webhook.Close()
+
+			got := received()
+			if len(got) != 1 {
+				t.Fatalf("want 1 webhook, got: %d", len(got))
+			}
+			if got[0].contentType != c.contentType {
+				t.Errorf("want content type: %s, got: %s", c.contentType, got[0].contentType)
+			}
+
+			body := map[string]interface{}{}
+			if err := json.Unmarshal(got[0].body, &body); err != nil {
+				t.Fatalf("webhook body is not JSON: %s", err)
+			}
+			c.check(t, body)
+		})
+	}
+}
+
+func Test_WebhookRetries(t *testing.T) {
+	cases := []struct {
+		name         string
+		statuses     []int
+		wantAttempts int
+	}{
+		{name: "retries server errors", statuses: []int{http.StatusInternalServerError, http.StatusBadGateway}, wantAttempts: 3},
+		{name: "retries too many requests", statuses: []int{http.StatusTooManyRequests}, wantAttempts: 2},
+		{name: "gives up after retries", statuses: []int{500, 500, 500, 500, 500}, wantAttempts: 4},
+		{name: "does not retry client errors", statuses: []int{http.StatusBadRequest}, wantAttempts: 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			receiver, received := newTestReceiver(c.statuses...)
+			defer receiver.Close()
+
+			webhook, err := newWebhookNotifier(receiver.URL, webhookFormatJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+			webhook.backoff = time.Millisecond
+			webhook.Emit(testEvent())
+			webhook.Close()
+
+			if got := len(received()); got != c.wantAttempts {
+				t.Errorf("want attempts: %d, got: %d", c.wantAttempts, got)
+			}
+		})
+	}
+}
+
+func Test_WebhookQueueDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer receiver.Close()
+
+	webhook, err := newWebhookNotifier(receiver.URL, webhookFormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < webhookQueueSize*2; i++ {
+			webhook.Emit(testEvent())
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("want Emit not to block on a slow receiver")
+	}
+
+	close(release)
+	webhook.Close()
+}
+
+func Test_WebhookDropWarningsAreLimited(t *testing.T) {
+	webhook := &webhookNotifier{url: "http://127.0.0.1"}
+	now := time.Now()
+
+	if err := webhook.dropWarning(now); err == nil {
+		t.Errorf("want a warning for the first dropped event")
+	}
+	for i := 0; i < 10; i++ {
+		if err := webhook.dropWarning(now.Add(time.Second)); err != nil {
+			t.Fatalf("want no warning within %s, got: %s", dropWarningInterval, err)
+		}
+	}
+
+	err := webhook.dropWarning(now.Add(dropWarningInterval))
+	if err == nil || !strings.Contains(err.Error(), "dropped 11 events") {
+		t.Errorf("want a warning counting 11 dropped events, got: %v", err)
+	}
+}
+
+func Test_WebhookFiltersEvents(t *testing.T) {
+	receiver, received := newTestReceiver()
+	defer receiver.Close()
+
+	webhook, err := newWebhookNotifier(receiver.URL, webhookFormatJSON, webhookEvents...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	skipped := testEvent()
+	skipped.Type = eventSkipped
+	webhook.Emit(skipped)
+	webhook.Emit(testEvent())
+	webhook.Close()
+
+	if got := len(received()); got != 1 {
+		t.Errorf("want only the idled event sent, got: %d", got)
+	}
+}
+
+func Test_WebhookUnknownFormat(t *testing.T) {
+	if _, err := newWebhookNotifier("http://127.0.0.1", "xml"); err == nil {
+		t.Errorf("want error for unknown format")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// planRecorder writes each decision as a line of JSON, so that plans from
+// dry-runs can be compared between runs.
+type planRecorder struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// newPlanRecorder appends records to the file at path, or writes them to
+// stdout when path is empty or "-".
+func newPlanRecorder(path string) (*planRecorder, error) {
+	if len(path) == 0 || path == "-" {
+		return &planRecorder{
+			encoder: json.NewEncoder(os.Stdout),
+		}, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &planRecorder{
+		encoder: json.NewEncoder(file),
+		closer:  file,
+	}, nil
+}
+
+// Record writes a single decision
+func (p *planRecorder) Record(decision Decision) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.encoder.Encode(decision)
+}
+
+// Close closes the underlying file, if any
+func (p *planRecorder) Close() error {
+	if p.closer == nil {
+		return nil
+	}
+	return p.closer.Close()
+}
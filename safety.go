@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	providerTypes "github.com/openfaas/faas-provider/types"
+)
+
+const (
+	// abortMetricsSourceError the invocation metrics could not be read
+	abortMetricsSourceError = "metrics-source-error"
+	// abortNoInvocations no invocations were counted across all functions,
+	// which is more likely a broken scrape than an idle platform
+	abortNoInvocations = "no-invocations"
+)
+
+// optedIn reports whether a function may be scaled to zero, functions
+// listed without labels are evaluated
+func optedIn(function providerTypes.FunctionStatus) bool {
+	if function.Labels == nil {
+		return true
+	}
+
+	labelValue := (*function.Labels)[scaleLabel]
+	return labelValue == "1" || labelValue == "true"
+}
+
+// idleBudget is how many more functions a cycle may scale to zero, a nil
+// budget is unlimited
+type idleBudget struct {
+	remaining int64
+}
+
+// newIdleBudget allows maxPercent of the eligible functions, rounded up, or
+// maxFunctions, whichever is fewer. Either limit is ignored when 0.
+func newIdleBudget(maxPercent int, maxFunctions int, eligible int) *idleBudget {
+	limit := -1
+	if maxPercent > 0 {
+		limit = int(math.Ceil(float64(eligible) * float64(maxPercent) / 100))
+	}
+	if maxFunctions > 0 && (limit < 0 || maxFunctions < limit) {
+		limit = maxFunctions
+	}
+
+	if limit < 0 {
+		return nil
+	}
+	return &idleBudget{remaining: int64(limit)}
+}
+
+// take uses one of the remaining scale-downs, or returns false when none
+// are left
+func (b *idleBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	return atomic.AddInt64(&b.remaining, -1) >= 0
+}
+
+// refund hands back a scale-down taken for a function which wasn't scaled
+func (b *idleBudget) refund() {
+	if b == nil {
+		return
+	}
+	atomic.AddInt64(&b.remaining, 1)
+}
+
+// checkMetricsSource returns why the cycle must be aborted, or an empty
+// string when the invocation metrics look sound. A total which drops to
+// zero after invocations were counted aborts cycles until it moves again,
+// a gateway which has never been invoked does not.
+func (c *Controller) checkMetricsSource(ctx context.Context) (string, error) {
+	if c.totalInvocations == nil {
+		return "", nil
+	}
+
+	total, err := c.totalInvocations(ctx)
+	if err != nil {
+		return abortMetricsSourceError, err
+	}
+	if total == 0 && c.invocationsSeen {
+		return abortNoInvocations, nil
+	}
+	if total > 0 {
+		c.invocationsSeen = true
+	}
+	return "", nil
+}
+
+// gatewayInvocationsTotal sums the invocations of every function from the
+// gateway's metrics
+func gatewayInvocationsTotal(ctx context.Context) (float64, error) {
+	code, body := Get(ctx, gatewayMetricsURL)
+	if code == 0 {
+		return 0, fmt.Errorf("unable to get metrics from %s", gatewayMetricsURL)
+	}
+	if code != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status code for metrics: %d", code)
+	}
+
+	return sumInvocations(string(body))
+}
+
+// sumInvocations adds up every gateway_function_invocation_total sample in
+// the Prometheus text format
+func sumInvocations(metrics string) (float64, error) {
+	var total float64
+	for _, row := range strings.Split(metrics, "\n") {
+		if !strings.HasPrefix(row, "gateway_function_invocation_total") {
+			continue
+		}
+
+		segs := strings.Fields(row)
+		if len(segs) < 2 {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(segs[1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("unable to parse metric: %s", row)
+		}
+		total += value
+	}
+	return total, nil
+}
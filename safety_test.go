@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func Test_newIdleBudget(t *testing.T) {
+	cases := []struct {
+		name         string
+		maxPercent   int
+		maxFunctions int
+		eligible     int
+		want         int
+	}{
+		{name: "unlimited", eligible: 10, want: -1},
+		{name: "percent", maxPercent: 50, eligible: 10, want: 5},
+		{name: "percent rounds up", maxPercent: 50, eligible: 3, want: 2},
+		{name: "percent of one", maxPercent: 10, eligible: 1, want: 1},
+		{name: "functions", maxFunctions: 3, eligible: 10, want: 3},
+		{name: "fewer of both", maxPercent: 50, maxFunctions: 3, eligible: 10, want: 3},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			budget := newIdleBudget(c.maxPercent, c.maxFunctions, c.eligible)
+			if c.want < 0 {
+				if budget != nil {
+					t.Errorf("want no budget, got: %d", budget.remaining)
+				}
+				return
+			}
+
+			got := 0
+			for budget.take() {
+				got++
+			}
+			if got != c.want {
+				t.Errorf("want: %d, got: %d", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_sumInvocations(t *testing.T) {
+	metrics := `# HELP gateway_function_invocation_total Individual function metrics
+# TYPE gateway_function_invocation_total counter
+gateway_function_invocation_total{code="200",function_name="figlet"} 16
+gateway_function_invocation_total{code="500",function_name="figlet"} 2
+gateway_function_invocation_total{code="200",function_name="nodeinfo"} 1.5e+01
+gateway_functions_seconds_count{code="200",function_name="figlet"} 18
+`
+
+	total, err := sumInvocations(metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 33 {
+		t.Errorf("want: 33, got: %v", total)
+	}
+}
+
+func Test_ControllerAbortsOnUntrustedMetrics(t *testing.T) {
+	cases := []struct {
+		name  string
+		seen  bool
+		total func(ctx context.Context) (float64, error)
+	}{
+		{name: "source error", total: func(ctx context.Context) (float64, error) { return 0, fmt.Errorf("connection refused") }},
+		{name: "no invocations", seen: true, total: func(ctx context.Context) (float64, error) { return 0, nil }},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			scaler := newMemoryScaler(labelled("figlet", 1))
+			controller := newTestController(scaler, &fakeInvocations{counts: map[string]float64{}})
+			controller.totalInvocations = c.total
+			controller.invocationsSeen = c.seen
+
+			if decisions := controller.reconcile(context.Background(), reconcileOptions{prime: true}); len(decisions) != 0 {
+				t.Errorf("want the cycle aborted, got: %d decisions", len(decisions))
+			}
+			if calls := scaler.Calls(); len(calls) != 0 {
+				t.Errorf("want no scale requests, got: %d", len(calls))
+			}
+		})
+	}
+}
+
+func Test_ControllerAbortsWhenInvocationsDrop(t *testing.T) {
+	scaler := newMemoryScaler(labelled("figlet", 1))
+	controller := newTestController(scaler, &fakeInvocations{counts: map[string]float64{}})
+
+	total := 0.0
+	controller.totalInvocations = func(ctx context.Context) (float64, error) { return total, nil }
+
+	if decisions := controller.reconcile(context.Background(), reconcileOptions{}); len(decisions) != 1 {
+		t.Fatalf("want a gateway which was never invoked evaluated, got: %d decisions", len(decisions))
+	}
+
+	total = 10
+	controller.reconcile(context.Background(), reconcileOptions{})
+
+	total = 0
+	if decisions := controller.reconcile(context.Background(), reconcileOptions{}); len(decisions) != 0 {
+		t.Errorf("want the cycle aborted when invocations drop to zero, got: %d decisions", len(decisions))
+	}
+}
+
+func Test_ControllerSkipsUnreadableInvocations(t *testing.T) {
+	scaler := newMemoryScaler(labelled("figlet", 1))
+	invocations := &fakeInvocations{counts: map[string]float64{}}
+	controller := newTestController(scaler, invocations)
+
+	controller.reconcile(context.Background(), reconcileOptions{})
+	invocations.broken = map[string]bool{"figlet": true}
+
+	decisions := controller.reconcile(context.Background(), reconcileOptions{})
+	if decisions[0].Reason != ReasonInvocationsUnknown {
+		t.Errorf("want: %s, got: %s", ReasonInvocationsUnknown, decisions[0].Reason)
+	}
+	if calls := scaler.Calls(); len(calls) != 0 {
+		t.Errorf("want no scale requests, got: %d", len(calls))
+	}
+}
+
+func Test_ControllerBlastRadius(t *testing.T) {
+	scaler := newMemoryScaler(labelled("a", 1), labelled("b", 1), labelled("c", 1), labelled("d", 1))
+	controller := newTestController(scaler, &fakeInvocations{counts: map[string]float64{}})
+	controller.totalInvocations = func(ctx context.Context) (float64, error) { return 10, nil }
+	controller.config.MaxIdlePercent = 50
+
+	reasons := map[Reason]int{}
+	for _, decision := range controller.reconcile(context.Background(), reconcileOptions{prime: true}) {
+		reasons[decision.Reason]++
+	}
+
+	if reasons[ReasonIdle] != 2 || reasons[ReasonBlastRadius] != 2 {
+		t.Errorf("want 2 %s and 2 %s, got: %v", ReasonIdle, ReasonBlastRadius, reasons)
+	}
+}
+
+// failingScaler fails scale requests for the functions in failing
+type failingScaler struct {
+	*memoryScaler
+	failing map[string]bool
+}
+
+func (f *failingScaler) Scale(ctx context.Context, fn Function, replicas uint64) (scaleResponse, error) {
+	if f.failing[fn.Name] {
+		return scaleResponse{}, fmt.Errorf("gateway unavailable")
+	}
+	return f.memoryScaler.Scale(ctx, fn, replicas)
+}
+
+func Test_ControllerBlastRadiusRefundsFailedScales(t *testing.T) {
+	memory := newMemoryScaler(labelled("a", 1), labelled("b", 1), labelled("c", 1))
+	controller := newTestController(memory, &fakeInvocations{counts: map[string]float64{}})
+	controller.scaler = &failingScaler{memoryScaler: memory, failing: map[string]bool{"a": true, "b": true}}
+	controller.config.MaxIdleFunctions = 1
+	controller.config.MaxConcurrentEvaluations = 1
+
+	reasons := map[Reason]int{}
+	for _, decision := range controller.reconcile(context.Background(), reconcileOptions{prime: true}) {
+		reasons[decision.Reason]++
+	}
+
+	if reasons[ReasonScaleFailed] != 2 || reasons[ReasonIdle] != 1 {
+		t.Errorf("want 2 %s and 1 %s, got: %v", ReasonScaleFailed, ReasonIdle, reasons)
+	}
+}
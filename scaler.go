@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	providerTypes "github.com/openfaas/faas-provider/types"
+)
+
+// Function identifies a function to a Scaler
+type Function struct {
+	Name      string
+	Namespace string
+}
+
+// Scaler reads and sets the replicas of functions. Each backend, such as
+// the gateway or Kubernetes, is a Scaler.
+type Scaler interface {
+	GetReplicas(ctx context.Context, fn Function) (*providerTypes.FunctionStatus, error)
+	Scale(ctx context.Context, fn Function, replicas uint64) (scaleResponse, error)
+}
+
+// FunctionLister lists functions with their labels and annotations
+type FunctionLister interface {
+	ListFunctions(ctx context.Context) ([]providerTypes.FunctionStatus, error)
+}
+
+// scaleResponse is how the backend answered a scale request
+type scaleResponse struct {
+	StatusCode int
+	Latency    time.Duration
+}
+
+// dryRunScaler reads replicas from the Scaler it wraps, but only logs
+// scale requests instead of making them
+type dryRunScaler struct {
+	Scaler
+}
+
+func newDryRunScaler(scaler Scaler) *dryRunScaler {
+	return &dryRunScaler{Scaler: scaler}
+}
+
+// Scale logs the request
+func (d *dryRunScaler) Scale(ctx context.Context, fn Function, replicas uint64) (scaleResponse, error) {
+	log.Info("dry-run, not scaling function", "function", fn.Name, "namespace", fn.Namespace, "replicas", replicas)
+	return scaleResponse{}, nil
+}
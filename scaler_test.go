@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+
+	providerTypes "github.com/openfaas/faas-provider/types"
+)
+
+// ScaleCall is a scale request made to a Scaler
+type ScaleCall struct {
+	Function Function
+	Replicas uint64
+}
+
+// memoryScaler keeps functions in memory, so that the controller can be
+// run without a gateway
+type memoryScaler struct {
+	lock      sync.Mutex
+	functions map[string]providerTypes.FunctionStatus
+	calls     []ScaleCall
+
+	// scaleErr is returned from Scale, if set
+	scaleErr error
+}
+
+func newMemoryScaler(functions ...providerTypes.FunctionStatus) *memoryScaler {
+	m := &memoryScaler{functions: map[string]providerTypes.FunctionStatus{}}
+	for _, function := range functions {
+		m.functions[function.Name] = function
+	}
+	return m
+}
+
+// ListFunctions returns every function, sorted by name
+func (m *memoryScaler) ListFunctions(ctx context.Context) ([]providerTypes.FunctionStatus, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	list := make([]providerTypes.FunctionStatus, 0, len(m.functions))
+	for _, function := range m.functions {
+		list = append(list, function)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// GetReplicas returns a function's replicas
+func (m *memoryScaler) GetReplicas(ctx context.Context, fn Function) (*providerTypes.FunctionStatus, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	function, ok := m.functions[fn.Name]
+	if !ok {
+		return nil, fmt.Errorf("function not found: %s", fn.Name)
+	}
+	return &function, nil
+}
+
+// Scale records the request and sets the function's replicas
+func (m *memoryScaler) Scale(ctx context.Context, fn Function, replicas uint64) (scaleResponse, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.calls = append(m.calls, ScaleCall{Function: fn, Replicas: replicas})
+	if m.scaleErr != nil {
+		return scaleResponse{}, m.scaleErr
+	}
+
+	function, ok := m.functions[fn.Name]
+	if !ok {
+		return scaleResponse{}, fmt.Errorf("function not found: %s", fn.Name)
+	}
+
+	function.Replicas = replicas
+	function.AvailableReplicas = replicas
+	m.functions[fn.Name] = function
+	return scaleResponse{StatusCode: http.StatusOK}, nil
+}
+
+// Calls returns the scale requests made so far
+func (m *memoryScaler) Calls() []ScaleCall {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return append([]ScaleCall{}, m.calls...)
+}
+
+func Test_DryRunScalerDoesNotScale(t *testing.T) {
+	memory := newMemoryScaler(providerTypes.FunctionStatus{Name: "figlet", Replicas: 1, AvailableReplicas: 1})
+	scaler := newDryRunScaler(memory)
+
+	fn := Function{Name: "figlet"}
+	if _, err := scaler.Scale(context.Background(), fn, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := scaler.GetReplicas(context.Background(), fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Replicas != 1 {
+		t.Errorf("want figlet left at 1 replica, got: %d", status.Replicas)
+	}
+
+	if calls := memory.Calls(); len(calls) != 0 {
+		t.Errorf("want no scale requests made, got: %v", calls)
+	}
+}
+
+func Test_MemoryScalerScales(t *testing.T) {
+	scaler := newMemoryScaler(providerTypes.FunctionStatus{Name: "figlet", Replicas: 2, AvailableReplicas: 2})
+
+	fn := Function{Name: "figlet"}
+	if _, err := scaler.Scale(context.Background(), fn, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := scaler.GetReplicas(context.Background(), fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Replicas != 0 || status.AvailableReplicas != 0 {
+		t.Errorf("want figlet scaled to 0, got: %+v", status)
+	}
+
+	if _, err := scaler.GetReplicas(context.Background(), Function{Name: "missing"}); err == nil {
+		t.Errorf("want error for missing function")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// newHandler serves the idler's HTTP API
+func newHandler(p *probes, admin *adminAPI) http.Handler {
+	mux := http.NewServeMux()
+	admin.register(mux)
+	mux.HandleFunc("/status", makeStatusHandler(state))
+	mux.HandleFunc("/status/", makeStatusHandler(state))
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", p.healthz)
+	mux.HandleFunc("/readyz", p.readyz)
+	mux.HandleFunc("/livez", p.livez)
+	return mux
+}
+
+// makeStatusHandler lists the tracked functions on /status and shows a
+// single function on /status/{namespace}/{name}, or /status/{name} when the
+// provider has no namespaces.
+func makeStatusHandler(s *idlerState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/status"), "/")
+		if len(path) == 0 {
+			writeJSON(w, http.StatusOK, s.functions())
+			return
+		}
+
+		var namespace, name string
+		parts := strings.Split(path, "/")
+		switch len(parts) {
+		case 1:
+			name = parts[0]
+		case 2:
+			namespace, name = parts[0], parts[1]
+		default:
+			http.Error(w, "use /status/{namespace}/{name}", http.StatusBadRequest)
+			return
+		}
+
+		function, ok := s.function(name)
+		if !ok || (len(namespace) > 0 && function.Namespace != namespace) {
+			http.Error(w, "function not tracked: "+path, http.StatusNotFound)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, function)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	bytesOut, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bytesOut)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_StatusHandler(t *testing.T) {
+	s := newIdlerState()
+	s.setTouch("figlet", 10)
+	s.setDecision(Decision{
+		Function:          "figlet",
+		Namespace:         "openfaas-fn",
+		AvailableReplicas: 1,
+		Policy: &Policy{
+			Label:              scaleLabel + "=true",
+			InactivityDuration: Duration(5 * time.Minute),
+		},
+		Action: actionSkip,
+		Reason: ReasonCounterChanged,
+	})
+	s.setTouch("nodeinfo", 2)
+
+	handler := makeStatusHandler(s)
+
+	t.Run("list", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("want status: %d, got: %d", http.StatusOK, rec.Code)
+		}
+
+		functions := []FunctionState{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &functions); err != nil {
+			t.Fatal(err)
+		}
+		if len(functions) != 2 || functions[0].Name != "figlet" || functions[1].Name != "nodeinfo" {
+			t.Errorf("want figlet and nodeinfo, got: %+v", functions)
+		}
+	})
+
+	t.Run("single function", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/status/openfaas-fn/figlet", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("want status: %d, got: %d", http.StatusOK, rec.Code)
+		}
+
+		function := FunctionState{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &function); err != nil {
+			t.Fatal(err)
+		}
+		if function.Replicas != 1 || function.LastCount != 10 {
+			t.Errorf("want 1 replica and count 10, got: %+v", function)
+		}
+		if function.LastDecision == nil || function.LastDecision.Reason != ReasonCounterChanged {
+			t.Errorf("want last decision: %s, got: %+v", ReasonCounterChanged, function.LastDecision)
+		}
+		if function.TimeUntilIdle == nil || time.Duration(*function.TimeUntilIdle) > 5*time.Minute {
+			t.Errorf("want time until idle within the inactivity duration, got: %v", function.TimeUntilIdle)
+		}
+	})
+
+	t.Run("wrong namespace", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/status/other/figlet", nil))
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("want status: %d, got: %d", http.StatusNotFound, rec.Code)
+		}
+	})
+
+	t.Run("untracked function", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/status/openfaas-fn/missing", nil))
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("want status: %d, got: %d", http.StatusNotFound, rec.Code)
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+
+	providerTypes "github.com/openfaas/faas-provider/types"
+)
+
+// shardPoints is how many points each shard has on the ring, more points
+// spread functions more evenly
+const shardPoints = 128
+
+// shardRing assigns each function to one of count idlers by consistent
+// hashing, so that changing count only moves the functions of the shards
+// added or removed. A nil shardRing owns every function.
+type shardRing struct {
+	index  int
+	count  int
+	points []uint64
+	owners map[uint64]int
+}
+
+// newShardRing returns nil when there is only one shard
+func newShardRing(index int, count int) *shardRing {
+	if count <= 1 {
+		return nil
+	}
+
+	r := &shardRing{
+		index:  index,
+		count:  count,
+		owners: make(map[uint64]int, count*shardPoints),
+	}
+
+	for shard := 0; shard < count; shard++ {
+		for point := 0; point < shardPoints; point++ {
+			hash := hashKey("shard-" + strconv.Itoa(shard) + "-" + strconv.Itoa(point))
+			if _, taken := r.owners[hash]; taken {
+				continue
+			}
+			r.owners[hash] = shard
+			r.points = append(r.points, hash)
+		}
+	}
+
+	sort.Slice(r.points, func(i, j int) bool {
+		return r.points[i] < r.points[j]
+	})
+	return r
+}
+
+// owner returns the shard of a function, the first point on the ring at or
+// after its hash
+func (r *shardRing) owner(key string) int {
+	hash := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= hash
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// filter returns the functions owned by this idler
+func (r *shardRing) filter(functions []providerTypes.FunctionStatus) []providerTypes.FunctionStatus {
+	if r == nil {
+		return functions
+	}
+
+	owned := make([]providerTypes.FunctionStatus, 0, len(functions)/r.count+1)
+	for _, function := range functions {
+		if r.owner(shardKey(function)) == r.index {
+			owned = append(owned, function)
+		}
+	}
+	return owned
+}
+
+// shardKey identifies a function on the ring
+func shardKey(function providerTypes.FunctionStatus) string {
+	if len(function.Namespace) == 0 {
+		return function.Name
+	}
+	return function.Namespace + "/" + function.Name
+}
+
+func hashKey(key string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	return mix(hash.Sum64())
+}
+
+// mix spreads the bits of an FNV hash, which clusters for keys that differ
+// only in their last characters
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	providerTypes "github.com/openfaas/faas-provider/types"
+)
+
+func testFunctions(n int) []providerTypes.FunctionStatus {
+	functions := make([]providerTypes.FunctionStatus, 0, n)
+	for i := 0; i < n; i++ {
+		functions = append(functions, providerTypes.FunctionStatus{
+			Name:      fmt.Sprintf("fn-%d", i),
+			Namespace: "openfaas-fn",
+		})
+	}
+	return functions
+}
+
+// owners returns the shard of each function when split count ways
+func owners(functions []providerTypes.FunctionStatus, count int) map[string]int {
+	result := map[string]int{}
+	for index := 0; index < count; index++ {
+		for _, function := range newShardRing(index, count).filter(functions) {
+			if shard, ok := result[function.Name]; ok {
+				panic(fmt.Sprintf("%s owned by shards %d and %d", function.Name, shard, index))
+			}
+			result[function.Name] = index
+		}
+	}
+	return result
+}
+
+func Test_ShardRingAssignsEveryFunctionOnce(t *testing.T) {
+	functions := testFunctions(10000)
+	assigned := owners(functions, 4)
+
+	if len(assigned) != len(functions) {
+		t.Fatalf("want every function owned, got: %d of %d", len(assigned), len(functions))
+	}
+
+	perShard := map[int]int{}
+	for _, shard := range assigned {
+		perShard[shard]++
+	}
+
+	for shard := 0; shard < 4; shard++ {
+		if got := perShard[shard]; got < 1500 || got > 3500 {
+			t.Errorf("want shard %d to own about 2500 functions, got: %d", shard, got)
+		}
+	}
+}
+
+func Test_ShardRingIsStable(t *testing.T) {
+	functions := testFunctions(1000)
+	first := owners(functions, 5)
+
+	reversed := make([]providerTypes.FunctionStatus, len(functions))
+	for i, function := range functions {
+		reversed[len(functions)-1-i] = function
+	}
+
+	second := owners(reversed, 5)
+	for name, shard := range first {
+		if second[name] != shard {
+			t.Errorf("want %s to stay on shard %d, got: %d", name, shard, second[name])
+		}
+	}
+}
+
+func Test_ShardRingRebalances(t *testing.T) {
+	functions := testFunctions(10000)
+
+	cases := []struct {
+		name     string
+		from     int
+		to       int
+		maxMoved float64
+		check    func(t *testing.T, name string, from int, to int)
+	}{
+		{
+			name:     "adding a shard",
+			from:     4,
+			to:       5,
+			maxMoved: 0.3,
+			check: func(t *testing.T, name string, from int, to int) {
+				if to != 4 {
+					t.Errorf("want %s moved to the new shard 4, got: %d", name, to)
+				}
+			},
+		},
+		{
+			name:     "removing a shard",
+			from:     5,
+			to:       4,
+			maxMoved: 0.3,
+			check: func(t *testing.T, name string, from int, to int) {
+				if from != 4 {
+					t.Errorf("want only functions of the removed shard 4 moved, %s moved from: %d", name, from)
+				}
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			before := owners(functions, c.from)
+			after := owners(functions, c.to)
+
+			moved := 0
+			for name, from := range before {
+				if to := after[name]; to != from {
+					moved++
+					c.check(t, name, from, to)
+				}
+			}
+
+			if fraction := float64(moved) / float64(len(functions)); fraction == 0 || fraction > c.maxMoved {
+				t.Errorf("want about 1/5 of functions moved, got: %.2f", fraction)
+			}
+		})
+	}
+}
+
+func Test_NilShardRingOwnsEverything(t *testing.T) {
+	if ring := newShardRing(0, 1); ring != nil {
+		t.Fatalf("want no ring for a single shard")
+	}
+
+	var ring *shardRing
+	if got := len(ring.filter(testFunctions(10))); got != 10 {
+		t.Errorf("want all 10 functions, got: %d", got)
+	}
+}
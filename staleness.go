@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/openfaas/faas/gateway/metrics"
+)
+
+// errGatewayDown is returned when Prometheus has no successful scrape of
+// the gateway to date the metrics by
+var errGatewayDown = errors.New("no successful scrape of the gateway in prometheus")
+
+// stalenessQuery returns when the gateway was last scraped successfully,
+// nothing is returned while the latest scrape of every target failed
+func stalenessQuery(job string) string {
+	return `max(timestamp(up{job="` + job + `"} == 1))`
+}
+
+// prometheusMetricsAge returns how long ago Prometheus last scraped the
+// gateway successfully, as it keeps returning the last value it scraped
+// when the gateway goes away
+func prometheusMetricsAge(query metrics.PrometheusQueryFetcher, job string, now time.Time) (time.Duration, error) {
+	res, err := query.Fetch(url.QueryEscape(stalenessQuery(job)))
+	if err != nil {
+		return 0, err
+	}
+
+	if len(res.Data.Result) == 0 {
+		return 0, errGatewayDown
+	}
+
+	value := res.Data.Result[0].Value
+	if len(value) < 2 {
+		return 0, fmt.Errorf("unexpected sample from prometheus: %v", value)
+	}
+
+	sample, ok := value[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("unexpected sample from prometheus: %v", value)
+	}
+
+	seconds, err := strconv.ParseFloat(sample, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected sample from prometheus: %s", err)
+	}
+
+	scraped := time.Unix(0, int64(seconds*float64(time.Second)))
+	return now.Sub(scraped), nil
+}
+
+// prometheusJobScraped reports whether Prometheus has any target for job,
+// so that a gateway_job which matches nothing is reported at startup
+// rather than refusing every scale-down
+func prometheusJobScraped(query metrics.PrometheusQueryFetcher, job string) (bool, error) {
+	res, err := query.Fetch(url.QueryEscape(`count(up{job="` + job + `"})`))
+	if err != nil {
+		return false, err
+	}
+	return len(res.Data.Result) > 0, nil
+}
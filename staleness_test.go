@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas/gateway/metrics"
+)
+
+func newTestPrometheus(t *testing.T, want string, body string) (metrics.PrometheusQuery, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("query") != want {
+			t.Errorf("want query: %s, got: %s", want, r.URL.Query().Get("query"))
+		}
+		w.Write([]byte(body))
+	}))
+
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	return metrics.NewPrometheusQuery(host, portNumber, server.Client()), server.Close
+}
+
+func Test_prometheusMetricsAge(t *testing.T) {
+	now := time.Unix(1584000000, 0)
+
+	cases := []struct {
+		name    string
+		body    string
+		want    time.Duration
+		wantErr error
+	}{
+		{
+			name: "recent scrape",
+			body: `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1584000000,"1583999985.5"]}]}}`,
+			want: 14500 * time.Millisecond,
+		},
+		{
+			name:    "gateway down",
+			body:    `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+			wantErr: errGatewayDown,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			query, done := newTestPrometheus(t, stalenessQuery("gateway"), c.body)
+			defer done()
+
+			age, err := prometheusMetricsAge(query, "gateway", now)
+			if err != c.wantErr {
+				t.Fatalf("want error: %v, got: %v", c.wantErr, err)
+			}
+			if age != c.want {
+				t.Errorf("want age: %s, got: %s", c.want, age)
+			}
+		})
+	}
+}
+
+func Test_ControllerRefusesStaleMetrics(t *testing.T) {
+	cases := []struct {
+		name       string
+		sampleAge  time.Duration
+		metricsAge func() (time.Duration, error)
+		want       Reason
+	}{
+		{name: "counters only", want: ReasonIdle},
+		{name: "old samples read fresh", sampleAge: 5 * time.Minute, want: ReasonStaleMetrics},
+		{name: "fresh", metricsAge: func() (time.Duration, error) { return 15 * time.Second, nil }, want: ReasonIdle},
+		{name: "stale", metricsAge: func() (time.Duration, error) { return 5 * time.Minute, nil }, want: ReasonStaleMetrics},
+		{name: "unknown", metricsAge: func() (time.Duration, error) { return 0, fmt.Errorf("prometheus unavailable") }, want: ReasonStaleMetrics},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			scaler := newMemoryScaler(labelled("figlet", 1))
+			controller := newTestController(scaler, &fakeInvocations{counts: map[string]float64{}, age: c.sampleAge})
+			controller.config.StalenessThreshold = time.Minute
+			controller.metricsAge = c.metricsAge
+
+			decisions := controller.reconcile(context.Background(), reconcileOptions{prime: true})
+			if decisions[0].Reason != c.want {
+				t.Errorf("want: %s, got: %s", c.want, decisions[0].Reason)
+			}
+		})
+	}
+}
+
+func Test_prometheusJobScraped(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{name: "scraped", body: `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1584000000,"1"]}]}}`, want: true},
+		{name: "no series", body: `{"status":"success","data":{"resultType":"vector","result":[]}}`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			query, done := newTestPrometheus(t, `count(up{job="gateway"})`, c.body)
+			defer done()
+
+			scraped, err := prometheusJobScraped(query, "gateway")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if scraped != c.want {
+				t.Errorf("want: %v, got: %v", c.want, scraped)
+			}
+		})
+	}
+}
+
+func Test_ControllerStaleCounters(t *testing.T) {
+	controller := newTestController(newMemoryScaler(), &fakeInvocations{})
+	controller.config.StalenessThreshold = time.Minute
+
+	if controller.stale(time.Now().Add(-10*time.Second), log) {
+		t.Errorf("want counters sampled 10s ago fresh")
+	}
+	if !controller.stale(time.Now().Add(-2*time.Minute), log) {
+		t.Errorf("want counters sampled 2m ago stale")
+	}
+}
+
+func Test_parseInvocationTotal(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name        string
+		metrics     string
+		want        float64
+		wantSampled time.Time
+	}{
+		{
+			name: "gateway",
+			metrics: `gateway_function_invocation_total{code="200",function_name="figlet"} 16
+gateway_function_invocation_total{code="500",function_name="figlet"} 2
+gateway_function_invocation_total{code="200",function_name="nodeinfo"} 5`,
+			want:        18,
+			wantSampled: now,
+		},
+		{
+			name: "federated",
+			metrics: `gateway_function_invocation_total{code="200",function_name="figlet"} 16 1500000000000
+gateway_function_invocation_total{code="500",function_name="figlet"} 2 1500000030000`,
+			want:        18,
+			wantSampled: time.Unix(1500000030, 0),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			total, sampled, err := parseInvocationTotal(c.metrics, "figlet", now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != c.want {
+				t.Errorf("want: %v, got: %v", c.want, total)
+			}
+			if !sampled.Equal(c.wantSampled) {
+				t.Errorf("want sampled: %s, got: %s", c.wantSampled, sampled)
+			}
+		})
+	}
+}
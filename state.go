@@ -0,0 +1,448 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// FunctionState is what the idler knows about a tracked function
+type FunctionState struct {
+	Name          string     `json:"name"`
+	Namespace     string     `json:"namespace,omitempty"`
+	Replicas      uint64     `json:"replicas"`
+	LastCount     float64    `json:"lastCount"`
+	LastActivity  *time.Time `json:"lastActivity,omitempty"`
+	TimeUntilIdle *Duration  `json:"timeUntilIdle,omitempty"`
+	ExemptUntil   *time.Time `json:"exemptUntil,omitempty"`
+	Policy        *Policy    `json:"policy,omitempty"`
+	ColdStart     *ColdStart `json:"coldStart,omitempty"`
+	Flapping      *Flapping  `json:"flapping,omitempty"`
+	LastDecision  *Decision  `json:"lastDecision,omitempty"`
+}
+
+// functionRecord is the state kept for each tracked function
+type functionRecord struct {
+	count        float64
+	firstSeen    time.Time
+	lastActivity time.Time
+	decision     *Decision
+
+	// meanGap is a moving average of the time between invocations
+	meanGap time.Duration
+
+	// coldStart was measured the last time the function was woken
+	coldStart time.Duration
+
+	// wakeBaseline is set while a function scaled to zero waits for its
+	// first requests, to measure its cold start by
+	wakeBaseline *functionSeconds
+
+	// idledAt is when the idler scaled the function to zero, until it is
+	// seen to wake
+	idledAt time.Time
+	wokenAt time.Time
+
+	// backoff is how many times the inactivity duration is doubled after
+	// the function flapped, decaying from lastFlap
+	backoff  int
+	lastFlap time.Time
+}
+
+// gapWeight is how much each new gap between invocations counts towards the
+// moving average
+const gapWeight = 0.2
+
+// idlerState holds the invocation counters and decisions seen so far, it is
+// shared between reconcile goroutines and the HTTP API.
+type idlerState struct {
+	lock      sync.RWMutex
+	records   map[string]*functionRecord
+	lastCycle time.Time
+
+	// lastProgress is when a function was last read or scaled, or the
+	// inactivity duration last passed, during a cycle
+	lastProgress time.Time
+
+	// paused stops all scale-downs until pausedUntil, or until resumed
+	// when pausedUntil is zero
+	paused      bool
+	pausedUntil time.Time
+
+	// exemptions stop scale-downs of the functions they match
+	exemptions *exemptionStore
+}
+
+func newIdlerState() *idlerState {
+	return &idlerState{
+		records:    make(map[string]*functionRecord),
+		exemptions: newExemptionStore(),
+	}
+}
+
+func (s *idlerState) touch(name string) (float64, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	record, ok := s.records[name]
+	if !ok {
+		return 0, false
+	}
+	return record.count, true
+}
+
+// setTouch caches the latest counter for a function and records activity
+// when it moved since the last time it was seen.
+func (s *idlerState) setTouch(name string, count float64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	record, ok := s.records[name]
+	if !ok {
+		s.records[name] = &functionRecord{
+			count:     count,
+			firstSeen: time.Now(),
+		}
+		return
+	}
+
+	if record.count != count {
+		now := time.Now()
+
+		if calls := count - record.count; calls > 0 {
+			since := record.firstSeen
+			if !record.lastActivity.IsZero() {
+				since = record.lastActivity
+			}
+
+			gap := time.Duration(float64(now.Sub(since)) / calls)
+			if record.meanGap == 0 {
+				record.meanGap = gap
+			} else {
+				record.meanGap = time.Duration(gapWeight*float64(gap) + (1-gapWeight)*float64(record.meanGap))
+			}
+		}
+		record.lastActivity = now
+	}
+	record.count = count
+}
+
+// expectedGap is how long until a function is next expected to be called,
+// its average gap between invocations or, when longer, how long it has
+// already gone without one
+func (s *idlerState) expectedGap(name string, now time.Time) (time.Duration, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	record, ok := s.records[name]
+	if !ok {
+		return 0, false
+	}
+
+	since := record.firstSeen
+	if !record.lastActivity.IsZero() {
+		since = record.lastActivity
+	}
+
+	gap := now.Sub(since)
+	if record.meanGap > gap {
+		gap = record.meanGap
+	}
+	return gap, true
+}
+
+// setWakeBaseline keeps the request durations of a function as it is
+// scaled to zero
+func (s *idlerState) setWakeBaseline(name string, seconds functionSeconds) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if record, ok := s.records[name]; ok {
+		record.wakeBaseline = &seconds
+	}
+}
+
+func (s *idlerState) wakeBaseline(name string) (functionSeconds, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	record, ok := s.records[name]
+	if !ok || record.wakeBaseline == nil {
+		return functionSeconds{}, false
+	}
+	return *record.wakeBaseline, true
+}
+
+// setMeasuredColdStart records the cold start of a function, which ends
+// its wake baseline
+func (s *idlerState) setMeasuredColdStart(name string, coldStart time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if record, ok := s.records[name]; ok {
+		record.coldStart = coldStart
+		record.wakeBaseline = nil
+	}
+}
+
+func (s *idlerState) measuredColdStart(name string) time.Duration {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if record, ok := s.records[name]; ok {
+		return record.coldStart
+	}
+	return 0
+}
+
+// setIdled records when a function was scaled to zero by the idler
+func (s *idlerState) setIdled(name string, at time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if record, ok := s.records[name]; ok {
+		record.idledAt = at
+	}
+}
+
+// wake ends the idle period of a function scaled to zero by the idler once
+// it has replicas again or its counter has moved, returning how long it
+// stayed idle
+func (s *idlerState) wake(name string, replicas uint64, now time.Time) (time.Duration, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	record, ok := s.records[name]
+	if !ok || record.idledAt.IsZero() {
+		return 0, false
+	}
+
+	// the counter moving is the earlier sign of the two
+	wokenAt := now
+	if record.lastActivity.After(record.idledAt) {
+		wokenAt = record.lastActivity
+	} else if replicas == 0 {
+		return 0, false
+	}
+
+	idled := wokenAt.Sub(record.idledAt)
+	record.idledAt = time.Time{}
+	record.wokenAt = wokenAt
+	return idled, true
+}
+
+// addFlap doubles the inactivity duration of a function once more, up to
+// maxBackoff times, after the backoff it had has decayed
+func (s *idlerState) addFlap(name string, now time.Time, maxBackoff int, decay time.Duration) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	record, ok := s.records[name]
+	if !ok {
+		return 0
+	}
+
+	record.backoff = decayBackoff(record.backoff, record.lastFlap, now, decay) + 1
+	if record.backoff > maxBackoff {
+		record.backoff = maxBackoff
+	}
+	record.lastFlap = now
+	return record.backoff
+}
+
+// backoff is how many times the inactivity duration of a function is
+// doubled at now, and when it last flapped
+func (s *idlerState) backoff(name string, now time.Time, decay time.Duration) (int, time.Time) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	record, ok := s.records[name]
+	if !ok {
+		return 0, time.Time{}
+	}
+	return decayBackoff(record.backoff, record.lastFlap, now, decay), record.lastFlap
+}
+
+// quietSince is when a function was last called or woken, or first seen
+// when neither
+func (s *idlerState) quietSince(name string) time.Time {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	record, ok := s.records[name]
+	if !ok {
+		return time.Time{}
+	}
+
+	since := record.firstSeen
+	if record.lastActivity.After(since) {
+		since = record.lastActivity
+	}
+	if record.wokenAt.After(since) {
+		since = record.wokenAt
+	}
+	return since
+}
+
+// lastActivity is when the counter of a function last moved, if it has been
+// seen to move at all.
+func (s *idlerState) lastActivity(name string) (time.Time, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	record, ok := s.records[name]
+	if !ok || record.lastActivity.IsZero() {
+		return time.Time{}, false
+	}
+	return record.lastActivity, true
+}
+
+// setDecision keeps the latest decision for a tracked function
+func (s *idlerState) setDecision(decision Decision) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if record, ok := s.records[decision.Function]; ok {
+		record.decision = &decision
+	}
+}
+
+func (s *idlerState) setLastCycle(completed time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.lastCycle = completed
+}
+
+// setProgress records that a cycle is still moving
+func (s *idlerState) setProgress(at time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.lastProgress = at
+}
+
+// progressed is when the last cycle completed or the current one last
+// moved, zero if neither has happened yet
+func (s *idlerState) progressed() time.Time {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.lastProgress.After(s.lastCycle) {
+		return s.lastProgress
+	}
+	return s.lastCycle
+}
+
+// lastCycleCompleted is when the last reconcile cycle finished, zero if
+// none has yet.
+func (s *idlerState) lastCycleCompleted() time.Time {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.lastCycle
+}
+
+// pause stops all scale-downs until a time, or until resume is called when
+// until is zero
+func (s *idlerState) pause(until time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.paused = true
+	s.pausedUntil = until
+}
+
+// resume ends a pause started by pause
+func (s *idlerState) resume() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.paused = false
+	s.pausedUntil = time.Time{}
+}
+
+// isPaused is true when all scale-downs are paused
+func (s *idlerState) isPaused(now time.Time) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.paused && (s.pausedUntil.IsZero() || now.Before(s.pausedUntil))
+}
+
+// functions lists every tracked function sorted by name
+func (s *idlerState) functions() []FunctionState {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	now := time.Now()
+	list := make([]FunctionState, 0, len(s.records))
+	for name, record := range s.records {
+		list = append(list, s.functionState(name, record, now))
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+// function looks up a single tracked function
+func (s *idlerState) function(name string) (FunctionState, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	record, ok := s.records[name]
+	if !ok {
+		return FunctionState{}, false
+	}
+	return s.functionState(name, record, time.Now()), true
+}
+
+// functionState must be called with the lock held
+func (s *idlerState) functionState(name string, r *functionRecord, now time.Time) FunctionState {
+	item := FunctionState{
+		Name:      name,
+		LastCount: r.count,
+	}
+
+	if exemption, ok := s.exemptions.Match(name, now); ok {
+		item.ExemptUntil = &exemption.Until
+	}
+
+	if !r.lastActivity.IsZero() {
+		lastActivity := r.lastActivity
+		item.LastActivity = &lastActivity
+	}
+
+	if r.decision == nil {
+		return item
+	}
+
+	decision := *r.decision
+	item.LastDecision = &decision
+	item.Namespace = decision.Namespace
+	item.Replicas = decision.AvailableReplicas
+	item.Policy = decision.Policy
+	item.ColdStart = decision.ColdStart
+	item.Flapping = decision.Flapping
+
+	// A function is idled once its counter has not moved for the inactivity
+	// duration, counting from when it was first seen if it never has.
+	if decision.Policy != nil && decision.AvailableReplicas > 0 {
+		since := r.firstSeen
+		if !r.lastActivity.IsZero() {
+			since = r.lastActivity
+		}
+
+		until := since.Add(time.Duration(decision.Policy.InactivityDuration)).Sub(now)
+		if until < 0 {
+			until = 0
+		}
+		timeUntilIdle := Duration(until)
+		item.TimeUntilIdle = &timeUntilIdle
+	}
+
+	return item
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openfaas-incubator/faas-idler/docker"
+	providerTypes "github.com/openfaas/faas-provider/types"
+)
+
+const backendSwarm = "swarm"
+
+const (
+	// swarmFunctionLabel marks the services faas-swarm deployed as functions
+	swarmFunctionLabel = "function=true"
+
+	// swarmAnnotationPrefix is how faas-swarm stores annotations as labels
+	swarmAnnotationPrefix = "com.openfaas.annotations."
+)
+
+// swarmScaler lists, reads and scales functions as Swarm services through
+// the Docker Engine API, without going through the gateway
+type swarmScaler struct {
+	client *docker.Client
+}
+
+// ListFunctions returns the services labelled as functions
+func (s *swarmScaler) ListFunctions(ctx context.Context) ([]providerTypes.FunctionStatus, error) {
+	services, err := s.client.ListServices(ctx, swarmFunctionLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	functions := make([]providerTypes.FunctionStatus, 0, len(services))
+	for _, service := range services {
+		labels, annotations := swarmLabels(service.Spec.Labels)
+
+		functions = append(functions, providerTypes.FunctionStatus{
+			Name:        service.Spec.Name,
+			Image:       service.Spec.TaskTemplate.ContainerSpec.Image,
+			Replicas:    swarmReplicas(service),
+			Labels:      &labels,
+			Annotations: &annotations,
+		})
+	}
+	return functions, nil
+}
+
+// GetReplicas reads a service's desired replicas and counts its running
+// tasks
+func (s *swarmScaler) GetReplicas(ctx context.Context, fn Function) (*providerTypes.FunctionStatus, error) {
+	service, err := s.client.InspectService(ctx, fn.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	running, err := s.client.RunningTasks(ctx, service.Spec.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &providerTypes.FunctionStatus{
+		Name:              service.Spec.Name,
+		Replicas:          swarmReplicas(*service),
+		AvailableReplicas: running,
+	}, nil
+}
+
+// Scale updates the replicas of a service
+func (s *swarmScaler) Scale(ctx context.Context, fn Function, replicas uint64) (scaleResponse, error) {
+	response := scaleResponse{}
+
+	start := time.Now()
+	err := s.client.ScaleService(ctx, fn.Name, replicas)
+	response.Latency = time.Since(start)
+
+	if err != nil {
+		if statusErr, ok := err.(*docker.StatusError); ok {
+			response.StatusCode = statusErr.Code
+			scaleErrorsTotal.WithLabelValues(strconv.Itoa(statusErr.Code)).Inc()
+		} else {
+			scaleErrorsTotal.WithLabelValues("error").Inc()
+		}
+		return response, err
+	}
+
+	response.StatusCode = http.StatusOK
+	log.Info("scaled function", "function", fn.Name, "replicas", replicas)
+	return response, nil
+}
+
+func swarmReplicas(service docker.Service) uint64 {
+	if service.Spec.Mode.Replicated == nil || service.Spec.Mode.Replicated.Replicas == nil {
+		return 0
+	}
+	return *service.Spec.Mode.Replicated.Replicas
+}
+
+// swarmLabels splits a service's labels into the function's labels and
+// annotations
+func swarmLabels(serviceLabels map[string]string) (map[string]string, map[string]string) {
+	labels := map[string]string{}
+	annotations := map[string]string{}
+
+	for key, value := range serviceLabels {
+		if strings.HasPrefix(key, swarmAnnotationPrefix) {
+			annotations[strings.TrimPrefix(key, swarmAnnotationPrefix)] = value
+			continue
+		}
+		labels[key] = value
+	}
+	return labels, annotations
+}
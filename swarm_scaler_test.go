@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openfaas-incubator/faas-idler/docker"
+)
+
+// newStubDocker serves a Swarm with a single figlet function, and records
+// the replicas it is updated to
+func newStubDocker(t *testing.T) (*swarmScaler, *[]float64, func()) {
+	service := `{
+		"ID": "abc123",
+		"Version": {"Index": 7},
+		"Spec": {
+			"Name": "figlet",
+			"Labels": {
+				"function": "true",
+				"com.openfaas.scale.zero": "true",
+				"com.openfaas.annotations.com.openfaas.scale.zero.exempt-until": "2020-03-16T18:00:00Z"
+			},
+			"Mode": {"Replicated": {"Replicas": 1}},
+			"TaskTemplate": {"ContainerSpec": {"Image": "functions/figlet:latest"}}
+		}
+	}`
+
+	updates := []float64{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[" + service + "]"))
+	})
+	mux.HandleFunc("/services/figlet", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(service))
+	})
+	mux.HandleFunc("/services/abc123/update", func(w http.ResponseWriter, r *http.Request) {
+		spec := struct {
+			Mode struct {
+				Replicated struct {
+					Replicas float64
+				}
+			}
+		}{}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &spec); err != nil {
+			t.Errorf("update is not JSON: %s", err)
+		}
+		updates = append(updates, spec.Mode.Replicated.Replicas)
+	})
+	mux.HandleFunc("/tasks", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"ID":"1","Status":{"State":"running"}}]`))
+	})
+
+	server := httptest.NewServer(mux)
+	client, err := docker.NewClient("tcp://" + strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &swarmScaler{client: client}, &updates, server.Close
+}
+
+func Test_SwarmScalerListsFunctions(t *testing.T) {
+	scaler, _, done := newStubDocker(t)
+	defer done()
+
+	functions, err := scaler.ListFunctions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(functions) != 1 {
+		t.Fatalf("want 1 function, got: %d", len(functions))
+	}
+
+	function := functions[0]
+	if function.Name != "figlet" || function.Replicas != 1 || function.Image != "functions/figlet:latest" {
+		t.Errorf("want figlet with 1 replica, got: %+v", function)
+	}
+	if (*function.Labels)[scaleLabel] != "true" {
+		t.Errorf("want label %s, got: %v", scaleLabel, *function.Labels)
+	}
+	if _, ok := (*function.Annotations)[exemptUntilAnnotation]; !ok {
+		t.Errorf("want annotation %s, got: %v", exemptUntilAnnotation, *function.Annotations)
+	}
+}
+
+func Test_SwarmScalerScales(t *testing.T) {
+	scaler, updates, done := newStubDocker(t)
+	defer done()
+
+	status, err := scaler.GetReplicas(context.Background(), Function{Name: "figlet"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Replicas != 1 || status.AvailableReplicas != 1 {
+		t.Errorf("want 1 replica available, got: %+v", status)
+	}
+
+	res, err := scaler.Scale(context.Background(), Function{Name: "figlet"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("want status code: %d, got: %d", http.StatusOK, res.StatusCode)
+	}
+	if len(*updates) != 1 || (*updates)[0] != 0 {
+		t.Errorf("want service updated to 0 replicas, got: %v", *updates)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,6 +14,148 @@ type Config struct {
 	InactivityDuration time.Duration
 	ReconcileInterval  time.Duration
 	PrometheusPort     int
+	HTTPPort           int
+
+	// ShutdownTimeout is how long requests in flight at shutdown, and then
+	// queued events and audit records, are given to complete
+	ShutdownTimeout time.Duration
+
+	// GatewayTimeout bounds each request to the gateway and its metrics
+	GatewayTimeout time.Duration
+
+	// GatewayRetries is how many times a failed read from the gateway is
+	// retried
+	GatewayRetries int
+
+	// CircuitBreakerFailures is how many gateway requests in a row must fail
+	// before calls to it are paused, 0 disables the circuit breaker
+	CircuitBreakerFailures int
+
+	// CircuitBreakerCooldown is how long calls to the gateway are paused for
+	CircuitBreakerCooldown time.Duration
+
+	// MaxConcurrentEvaluations bounds the functions evaluated at once, 0
+	// evaluates every function at once
+	MaxConcurrentEvaluations int
+
+	// MaxConcurrentScales bounds the scale requests in flight, 0 is unbounded
+	MaxConcurrentScales int
+
+	// ScaleRateLimit is how many functions may be scaled to zero a minute, 0
+	// is unlimited
+	ScaleRateLimit int
+
+	// MaxIdlePercent bounds the functions scaled to zero in a cycle to a
+	// percentage of those opted in, 0 is unbounded
+	MaxIdlePercent int
+
+	// MaxIdleFunctions bounds the functions scaled to zero in a cycle, 0 is
+	// unbounded
+	MaxIdleFunctions int
+
+	// MetricsSanityCheck aborts a cycle when the invocation metrics can't be
+	// read or count no invocations at all
+	MetricsSanityCheck bool
+
+	// StalenessThreshold is how old the invocation counters a function is
+	// idled on may be, 0 disables the check
+	StalenessThreshold time.Duration
+
+	// GatewayJob is the Prometheus job which scrapes the gateway, when set
+	// Prometheus must have scraped it within StalenessThreshold too
+	GatewayJob string
+
+	// LeaderElection lets only one of several idlers reconcile, through a
+	// "kubernetes" Lease or a "file" lock, and is off when empty
+	LeaderElection string
+
+	// LeaseName is the Lease, or the path of the file, used for leader
+	// election
+	LeaseName string
+
+	// LeaseNamespace is where the Lease is kept
+	LeaseNamespace string
+
+	// LeaseDuration is how long a leader which stops renewing keeps the
+	// lease
+	LeaseDuration time.Duration
+
+	// ShardCount is how many idlers share the functions between them
+	ShardCount int
+
+	// ShardIndex is which of the ShardCount shards this idler evaluates,
+	// taken from the StatefulSet ordinal in the hostname when not set
+	ShardIndex int
+
+	// ColdStartFactor keeps functions expected to be called again within
+	// this many times their cold start from being scaled to zero, 0
+	// disables the check
+	ColdStartFactor float64
+
+	// FlapWindow is how soon after being scaled to zero a function must be
+	// woken for its inactivity duration to be extended, 0 disables it
+	FlapWindow time.Duration
+
+	// FlapMaxBackoff is the most times the inactivity duration of a
+	// flapping function is doubled
+	FlapMaxBackoff int
+
+	// FlapDecay is how long a function must not flap for one doubling of
+	// its inactivity duration to be undone
+	FlapDecay time.Duration
+
+	// LivenessThreshold is how many reconcile intervals a cycle may overrun
+	// the inactivity duration by before the idler is reported as not alive
+	LivenessThreshold int
+
+	// Exemptions stop functions being scaled to zero until they expire
+	Exemptions []Exemption
+
+	// AuditLogFile is appended to with a JSON line for every scale request
+	AuditLogFile string
+
+	// AuditWebhookURL receives a POST for every scale request
+	AuditWebhookURL string
+
+	// WebhookURLs are sent an event when a function is scaled to zero, fails
+	// to scale or can't be evaluated
+	WebhookURLs []string
+
+	// WebhookFormat is the payload sent to WebhookURLs: json, slack or
+	// cloudevents
+	WebhookFormat string
+
+	// CloudEventsURL is sent a CloudEvent for every function evaluated,
+	// idled or woken
+	CloudEventsURL string
+
+	// CloudEventsTypes limits the events sent to CloudEventsURL, i.e. idled
+	// or skipped, all of them are sent when empty
+	CloudEventsTypes []string
+
+	// KubernetesEvents records an Event on a function's Deployment when it
+	// is scaled to zero, using the pod's service account
+	KubernetesEvents bool
+
+	// Backend reads and scales replicas through the "gateway", the
+	// "kubernetes" API using the pod's service account, or the Docker
+	// Engine's "swarm" API
+	Backend string
+
+	// DockerHost is the Docker Engine used by the swarm backend, as
+	// unix:///path/to/socket or tcp://host:port
+	DockerHost string
+
+	// FunctionNamespace is where the kubernetes backend looks for functions
+	// listed without a namespace, and where their Kubernetes Events go
+	FunctionNamespace string
+}
+
+// Exemption stops functions whose name matches Pattern, i.e. "payments-*",
+// being scaled to zero until a time
+type Exemption struct {
+	Pattern string
+	Until   time.Time
 }
 
 //ReadConfig reads configuration files
@@ -55,5 +198,346 @@ func ReadConfig() (Config, error) {
 		}
 		config.ReconcileInterval = parsedVal
 	}
+
+	config.ShutdownTimeout = time.Second * 10
+	if val, exists := os.LookupEnv("shutdown_timeout"); exists {
+		parsedVal, parseErr := time.ParseDuration(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		config.ShutdownTimeout = parsedVal
+	}
+
+	config.GatewayTimeout = time.Second * 10
+	if val, exists := os.LookupEnv("gateway_timeout"); exists {
+		parsedVal, parseErr := time.ParseDuration(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		config.GatewayTimeout = parsedVal
+	}
+
+	config.GatewayRetries = 3
+	if val, exists := os.LookupEnv("gateway_retries"); exists {
+		retries, parseErr := strconv.Atoi(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		config.GatewayRetries = retries
+	}
+
+	config.CircuitBreakerFailures = 5
+	if val, exists := os.LookupEnv("circuit_breaker_failures"); exists {
+		failures, parseErr := strconv.Atoi(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		config.CircuitBreakerFailures = failures
+	}
+
+	config.CircuitBreakerCooldown = time.Minute
+	if val, exists := os.LookupEnv("circuit_breaker_cooldown"); exists {
+		parsedVal, parseErr := time.ParseDuration(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		config.CircuitBreakerCooldown = parsedVal
+	}
+
+	config.MaxConcurrentEvaluations = 100
+	if val, exists := os.LookupEnv("max_concurrent_evaluations"); exists {
+		limit, parseErr := strconv.Atoi(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		config.MaxConcurrentEvaluations = limit
+	}
+
+	config.MaxConcurrentScales = 10
+	if val, exists := os.LookupEnv("max_concurrent_scales"); exists {
+		limit, parseErr := strconv.Atoi(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		config.MaxConcurrentScales = limit
+	}
+
+	config.ScaleRateLimit = 20
+	if val, exists := os.LookupEnv("scale_rate_limit"); exists {
+		limit, parseErr := strconv.Atoi(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		config.ScaleRateLimit = limit
+	}
+
+	config.MaxIdlePercent = 50
+	if val, exists := os.LookupEnv("max_idle_percent"); exists {
+		percent, parseErr := strconv.Atoi(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		if percent < 0 || percent > 100 {
+			return config, fmt.Errorf("max_idle_percent must be between 0 and 100, got: %d", percent)
+		}
+		config.MaxIdlePercent = percent
+	}
+
+	if val, exists := os.LookupEnv("max_idle_functions"); exists {
+		limit, parseErr := strconv.Atoi(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		config.MaxIdleFunctions = limit
+	}
+
+	config.MetricsSanityCheck = true
+	if val, exists := os.LookupEnv("metrics_sanity_check"); exists && len(val) > 0 {
+		enabled, parseErr := strconv.ParseBool(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		config.MetricsSanityCheck = enabled
+	}
+
+	config.StalenessThreshold = time.Minute
+	if val, exists := os.LookupEnv("staleness_threshold"); exists {
+		parsedVal, parseErr := time.ParseDuration(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		config.StalenessThreshold = parsedVal
+	}
+
+	config.GatewayJob = os.Getenv("gateway_job")
+
+	if val, exists := os.LookupEnv("leader_election"); exists && len(val) > 0 {
+		if val != "kubernetes" && val != "file" {
+			return config, fmt.Errorf("leader_election must be kubernetes or file, got: %s", val)
+		}
+		config.LeaderElection = val
+	}
+
+	config.LeaseName = "faas-idler"
+	if config.LeaderElection == "file" {
+		config.LeaseName = "/tmp/faas-idler.lock"
+	}
+	if val, exists := os.LookupEnv("lease_name"); exists && len(val) > 0 {
+		config.LeaseName = val
+	}
+
+	config.LeaseNamespace = "openfaas"
+	if val, exists := os.LookupEnv("lease_namespace"); exists && len(val) > 0 {
+		config.LeaseNamespace = val
+	}
+
+	config.LeaseDuration = time.Second * 15
+	if val, exists := os.LookupEnv("lease_duration"); exists {
+		parsedVal, parseErr := time.ParseDuration(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		if parsedVal < time.Second {
+			return config, fmt.Errorf("lease_duration must be at least 1s, got: %s", parsedVal)
+		}
+		config.LeaseDuration = parsedVal
+	}
+
+	config.ShardCount = 1
+	if val, exists := os.LookupEnv("shard_count"); exists && len(val) > 0 {
+		count, parseErr := strconv.Atoi(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		if count < 1 {
+			return config, fmt.Errorf("shard_count must be at least 1, got: %d", count)
+		}
+		config.ShardCount = count
+	}
+
+	if val, exists := os.LookupEnv("shard_index"); exists && len(val) > 0 {
+		index, parseErr := strconv.Atoi(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		config.ShardIndex = index
+	} else if config.ShardCount > 1 {
+		hostname, _ := os.Hostname()
+		index, parseErr := statefulSetOrdinal(hostname)
+		if parseErr != nil {
+			return config, fmt.Errorf("shard_index must be set when shard_count is, or the hostname end in a StatefulSet ordinal: %s", parseErr)
+		}
+		config.ShardIndex = index
+	}
+
+	if config.ShardIndex < 0 || config.ShardIndex >= config.ShardCount {
+		return config, fmt.Errorf("shard_index must be from 0 to %d, got: %d", config.ShardCount-1, config.ShardIndex)
+	}
+
+	config.ColdStartFactor = 3
+	if val, exists := os.LookupEnv("cold_start_factor"); exists && len(val) > 0 {
+		factor, parseErr := strconv.ParseFloat(val, 64)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		if factor < 0 {
+			return config, fmt.Errorf("cold_start_factor must not be negative, got: %s", val)
+		}
+		config.ColdStartFactor = factor
+	}
+
+	config.FlapWindow = 10 * time.Minute
+	if val, exists := os.LookupEnv("flap_window"); exists {
+		parsedVal, parseErr := time.ParseDuration(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		config.FlapWindow = parsedVal
+	}
+
+	config.FlapMaxBackoff = 4
+	if val, exists := os.LookupEnv("flap_max_backoff"); exists {
+		backoff, parseErr := strconv.Atoi(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		if backoff < 0 || backoff > 10 {
+			return config, fmt.Errorf("flap_max_backoff must be from 0 to 10, got: %d", backoff)
+		}
+		config.FlapMaxBackoff = backoff
+	}
+
+	config.FlapDecay = time.Hour
+	if val, exists := os.LookupEnv("flap_decay"); exists {
+		parsedVal, parseErr := time.ParseDuration(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		if parsedVal <= 0 {
+			return config, fmt.Errorf("flap_decay must be positive, got: %s", val)
+		}
+		config.FlapDecay = parsedVal
+	}
+
+	config.HTTPPort = 8080
+	if val, exists := os.LookupEnv("http_port"); exists {
+		port, parseErr := strconv.Atoi(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		config.HTTPPort = port
+	}
+
+	config.LivenessThreshold = 3
+	if val, exists := os.LookupEnv("liveness_threshold"); exists {
+		threshold, parseErr := strconv.Atoi(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		config.LivenessThreshold = threshold
+	}
+
+	config.AuditLogFile = os.Getenv("audit_log_file")
+	config.AuditWebhookURL = os.Getenv("audit_webhook_url")
+
+	if val, exists := os.LookupEnv("webhook_urls"); exists {
+		for _, url := range strings.Split(val, ",") {
+			if url = strings.TrimSpace(url); len(url) > 0 {
+				config.WebhookURLs = append(config.WebhookURLs, url)
+			}
+		}
+	}
+
+	config.CloudEventsURL = os.Getenv("cloudevents_url")
+
+	if val, exists := os.LookupEnv("cloudevents_types"); exists {
+		for _, eventType := range strings.Split(val, ",") {
+			if eventType = strings.TrimSpace(eventType); len(eventType) > 0 {
+				config.CloudEventsTypes = append(config.CloudEventsTypes, eventType)
+			}
+		}
+	}
+
+	if val, exists := os.LookupEnv("kubernetes_events"); exists && len(val) > 0 {
+		enabled, parseErr := strconv.ParseBool(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		config.KubernetesEvents = enabled
+	}
+
+	config.Backend = "gateway"
+	if val, exists := os.LookupEnv("backend"); exists && len(val) > 0 {
+		if val != "gateway" && val != "kubernetes" && val != "swarm" {
+			return config, fmt.Errorf("backend must be gateway, kubernetes or swarm, got: %s", val)
+		}
+		config.Backend = val
+	}
+
+	config.DockerHost = "unix:///var/run/docker.sock"
+	if val, exists := os.LookupEnv("docker_host"); exists && len(val) > 0 {
+		config.DockerHost = val
+	}
+
+	config.FunctionNamespace = "openfaas-fn"
+	if val, exists := os.LookupEnv("function_namespace"); exists && len(val) > 0 {
+		config.FunctionNamespace = val
+	}
+
+	config.WebhookFormat = "json"
+	if val, exists := os.LookupEnv("webhook_format"); exists && len(val) > 0 {
+		config.WebhookFormat = val
+	}
+
+	if val, exists := os.LookupEnv("exemptions"); exists && len(val) > 0 {
+		exemptions, parseErr := parseExemptions(val)
+		if parseErr != nil {
+			return config, parseErr
+		}
+		config.Exemptions = exemptions
+	}
 	return config, nil
 }
+
+// statefulSetOrdinal returns the ordinal a StatefulSet gives its pods at the
+// end of their hostname, i.e. 2 for "faas-idler-2"
+func statefulSetOrdinal(hostname string) (int, error) {
+	i := strings.LastIndex(hostname, "-")
+	if i < 0 {
+		return 0, fmt.Errorf("no ordinal in hostname: %s", hostname)
+	}
+
+	ordinal, err := strconv.Atoi(hostname[i+1:])
+	if err != nil || ordinal < 0 {
+		return 0, fmt.Errorf("no ordinal in hostname: %s", hostname)
+	}
+	return ordinal, nil
+}
+
+// parseExemptions reads a comma separated list of pattern=RFC3339 pairs,
+// i.e. "payments-*=2020-03-16T18:00:00Z"
+func parseExemptions(val string) ([]Exemption, error) {
+	exemptions := []Exemption{}
+	for _, item := range strings.Split(val, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, fmt.Errorf("exemption must be pattern=RFC3339 time, got: %s", item)
+		}
+
+		until, err := time.Parse(time.RFC3339, parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("exemption for %s: %s", parts[0], err)
+		}
+
+		exemptions = append(exemptions, Exemption{
+			Pattern: parts[0],
+			Until:   until,
+		})
+	}
+	return exemptions, nil
+}
@@ -16,6 +16,10 @@ func Test_properReadConfig(t *testing.T) {
 		prometheusPort     int
 		inactivityDuration time.Duration
 		reconcileInterval  time.Duration
+		httpPort           int
+		livenessThreshold  int
+		gatewayTimeout     time.Duration
+		gatewayRetries     int
 	}{
 		{
 			Case:               "default values",
@@ -25,6 +29,10 @@ func Test_properReadConfig(t *testing.T) {
 			prometheusPort:     9090,
 			inactivityDuration: time.Duration(5) * time.Minute,
 			reconcileInterval:  time.Duration(30) * time.Second,
+			httpPort:           8080,
+			livenessThreshold:  3,
+			gatewayTimeout:     time.Duration(10) * time.Second,
+			gatewayRetries:     3,
 		},
 		{
 			Case:               "manual values",
@@ -34,6 +42,7 @@ func Test_properReadConfig(t *testing.T) {
 			prometheusPort:     1234,
 			inactivityDuration: time.Duration(1) * time.Minute,  //i.e. "1m"
 			reconcileInterval:  time.Duration(45) * time.Second, //i.e. "45s"
+			httpPort:           8081,
 		},
 	}
 
@@ -52,6 +61,18 @@ func Test_properReadConfig(t *testing.T) {
 			if (test.reconcileInterval) != (config.ReconcileInterval) {
 				t.Errorf("Default time for reconcile interval should be: %s got :%s", test.reconcileInterval, config.ReconcileInterval)
 			}
+			if test.httpPort != config.HTTPPort {
+				t.Errorf("Default for HTTP port should be: %d got: %d.", test.httpPort, config.HTTPPort)
+			}
+			if test.livenessThreshold != config.LivenessThreshold {
+				t.Errorf("Default for liveness threshold should be: %d got: %d.", test.livenessThreshold, config.LivenessThreshold)
+			}
+			if test.gatewayTimeout != config.GatewayTimeout {
+				t.Errorf("Default for gateway timeout should be: %s got: %s.", test.gatewayTimeout, config.GatewayTimeout)
+			}
+			if test.gatewayRetries != config.GatewayRetries {
+				t.Errorf("Default for gateway retries should be: %d got: %d.", test.gatewayRetries, config.GatewayRetries)
+			}
 		}
 		if test.Case == "manual values" {
 			os.Setenv("gateway_url", test.gatewayURL)
@@ -59,6 +80,7 @@ func Test_properReadConfig(t *testing.T) {
 			os.Setenv("prometheus_port", strconv.Itoa(test.prometheusPort))
 			os.Setenv("inactivity_duration", test.inactivityDuration.String())
 			os.Setenv("reconcile_interval", test.reconcileInterval.String())
+			os.Setenv("http_port", strconv.Itoa(test.httpPort))
 			config, _ := ReadConfig()
 			if test.gatewayURL != config.GatewayURL {
 				t.Errorf("Gateway wanted: %s got :%s", test.gatewayURL, config.GatewayURL)
@@ -75,6 +97,9 @@ func Test_properReadConfig(t *testing.T) {
 			if test.reconcileInterval != config.ReconcileInterval {
 				t.Errorf("Reconcile interval wanted: %s got :%s", test.reconcileInterval.String(), config.ReconcileInterval.String())
 			}
+			if test.httpPort != config.HTTPPort {
+				t.Errorf("HTTP port wanted: %d got :%d", test.httpPort, config.HTTPPort)
+			}
 		}
 	}
 }
@@ -141,3 +166,49 @@ func Test_unproperReadConfig(t *testing.T) {
 		}
 	}
 }
+
+func Test_parseExemptions(t *testing.T) {
+	exemptions, err := parseExemptions("payments-*=2020-03-16T18:00:00Z, checkout=2020-03-17T09:30:00+01:00")
+	if err != nil {
+		t.Fatalf("Unexpected error :\n%s", err.Error())
+	}
+
+	if len(exemptions) != 2 {
+		t.Fatalf("Exemptions wanted: 2 got: %d", len(exemptions))
+	}
+	if exemptions[0].Pattern != "payments-*" || !exemptions[0].Until.Equal(time.Date(2020, 3, 16, 18, 0, 0, 0, time.UTC)) {
+		t.Errorf("Exemption wanted: payments-* until 2020-03-16T18:00:00Z got: %v", exemptions[0])
+	}
+	if exemptions[1].Pattern != "checkout" || !exemptions[1].Until.Equal(time.Date(2020, 3, 17, 8, 30, 0, 0, time.UTC)) {
+		t.Errorf("Exemption wanted: checkout until 2020-03-17T08:30:00Z got: %v", exemptions[1])
+	}
+
+	for _, bad := range []string{"payments-*", "=2020-03-16T18:00:00Z", "payments-*=tomorrow"} {
+		if _, err := parseExemptions(bad); err == nil {
+			t.Errorf("Had to have errors for exemption: %s", bad)
+		}
+	}
+}
+
+func Test_statefulSetOrdinal(t *testing.T) {
+	cases := []struct {
+		hostname string
+		want     int
+		wantErr  bool
+	}{
+		{hostname: "faas-idler-0", want: 0},
+		{hostname: "faas-idler-12", want: 12},
+		{hostname: "faas-idler-7d9f8b6c4-x2kq9", wantErr: true},
+		{hostname: "localhost", wantErr: true},
+	}
+
+	for _, c := range cases {
+		ordinal, err := statefulSetOrdinal(c.hostname)
+		if c.wantErr != (err != nil) {
+			t.Errorf("Ordinal of %s wanted error: %v got: %v", c.hostname, c.wantErr, err)
+		}
+		if ordinal != c.want {
+			t.Errorf("Ordinal of %s wanted: %d got: %d", c.hostname, c.want, ordinal)
+		}
+	}
+}